@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ANSI escape sequences of the `--color` output.
+const (
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// useColor decides on coloring for the `--color` mode: "always", "never" or
+// "auto", which colors only output to a terminal.
+func useColor(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return isTerminal(os.Stdout) && os.Getenv("TERM") != "dumb", nil
+	}
+	return false, fmt.Errorf("Invalid color mode: %s. Use `auto`, `always` or `never`", mode)
+}
+
+// isTerminal reports whether `f` is a character device like a terminal, as
+// opposed to a pipe or a regular file.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint wraps `line` in `color`, keeping a trailing newline outside of it.
+func paint(color, line string) string {
+	return color + strings.TrimSuffix(line, "\n") + colorReset + "\n"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/netip"
+)
+
+// flagConflicts lists options which can't be combined, each option by all
+// its names. Rather than letting one of them silently win, they are
+// rejected.
+var flagConflicts = [][2][]string{
+	{{"4", "ipv4"}, {"6"}},
+	{{"f", "flood"}, {"i", "interval"}},
+	{{"f", "flood"}, {"A", "adaptive"}},
+	{{"f", "flood"}, {"progress"}},
+	{{"n", "numeric"}, {"a", "resolve"}},
+	{{"once"}, {"c", "count"}},
+	{{"json"}, {"csv"}},
+	{{"table"}, {"json"}},
+	{{"table"}, {"csv"}},
+	{{"output-template", "format"}, {"json"}},
+	{{"output-template", "format"}, {"csv"}},
+	{{"ttl-sweep"}, {"t", "ttl"}},
+	{{"timestamp-probe"}, {"mask-probe"}},
+	{{"traceroute"}, {"mtu-discover"}},
+}
+
+// checkConflicts rejects combinations of options which contradict each
+// other, naming the options as given, and host names under -n.
+func checkConflicts(cfg *config) error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	return conflicts(cfg, given)
+}
+
+// conflicts does the work of checkConflicts, `given` holding the names of
+// the options on the command line.
+func conflicts(cfg *config, given map[string]bool) error {
+	// the first of the names which was given, "" if none
+	pick := func(names []string) string {
+		for _, name := range names {
+			if given[name] {
+				return name
+			}
+		}
+		return ""
+	}
+
+	for _, c := range flagConflicts {
+		a, b := pick(c[0]), pick(c[1])
+		if a != "" && b != "" {
+			return fmt.Errorf("Options %s and %s are mutually exclusive", flagName(a), flagName(b))
+		}
+	}
+
+	if cfg.numeric {
+		for _, host := range cfg.hosts {
+			// zoned IPv6 literals, e.g. fe80::1%eth0, are numeric too
+			if _, err := netip.ParseAddr(host); err != nil {
+				return fmt.Errorf("Option %s takes literal IP addresses only, %s is a host name", flagName(pick([]string{"n", "numeric"})), host)
+			}
+		}
+	}
+	return nil
+}
+
+// flagName formats the name of an option the way the usage text does, -x
+// for single letters and --name otherwise.
+func flagName(name string) string {
+	if len(name) == 1 {
+		return "-" + name
+	}
+	return "--" + name
+}
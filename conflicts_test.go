@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+func TestConflicts(t *testing.T) {
+	tests := []struct {
+		name    string
+		given   []string
+		numeric bool
+		hosts   []string
+		wantErr string
+	}{
+		{"nothing given", nil, false, []string{"example.com"}, ""},
+		{"unrelated options", []string{"c", "i", "json"}, false, nil, ""},
+		{"short names", []string{"4", "6"}, false, nil, "Options -4 and -6 are mutually exclusive"},
+		{"long name", []string{"flood", "i"}, false, nil, "Options --flood and -i are mutually exclusive"},
+		{"both long", []string{"json", "csv"}, false, nil, "Options --json and --csv are mutually exclusive"},
+		{"numeric IPv4", []string{"n"}, true, []string{"192.0.2.1"}, ""},
+		{"numeric IPv6", []string{"n"}, true, []string{"2001:db8::1"}, ""},
+		{"numeric zoned IPv6", []string{"n"}, true, []string{"fe80::1%lo"}, ""},
+		{"numeric host name", []string{"numeric"}, true, []string{"192.0.2.1", "example.com"}, "Option --numeric takes literal IP addresses only, example.com is a host name"},
+		{"numeric with resolve", []string{"n", "a"}, true, nil, "Options -n and -a are mutually exclusive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			given := make(map[string]bool)
+			for _, name := range tt.given {
+				given[name] = true
+			}
+			cfg := &config{numeric: tt.numeric, hosts: tt.hosts}
+
+			err := conflicts(cfg, given)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("conflicts() = %v, want no error", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("conflicts() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestConflictPairs gives every pair of flagConflicts, under each of their
+// names, on a command line, so a misspelt name doesn't go unnoticed.
+func TestConflictPairs(t *testing.T) {
+	defer func(saved *flag.FlagSet) { flag.CommandLine = saved }(flag.CommandLine)
+
+	// the argument setting option `name`, with a valid value
+	arg := func(t *testing.T, name string) string {
+		f := flag.CommandLine.Lookup(name)
+		if f == nil {
+			t.Fatalf("no option %s", flagName(name))
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			return "-" + name
+		}
+		return "-" + name + "=" + f.DefValue
+	}
+
+	for _, c := range flagConflicts {
+		for _, a := range c[0] {
+			for _, b := range c[1] {
+				t.Run(a+"/"+b, func(t *testing.T) {
+					flag.CommandLine = flag.NewFlagSet("pinger", flag.ContinueOnError)
+					cfg := &config{}
+					defineFlags(cfg)
+					if err := flag.CommandLine.Parse([]string{arg(t, a), arg(t, b), "192.0.2.1"}); err != nil {
+						t.Fatal(err)
+					}
+					cfg.hosts = flag.Args()
+
+					err := checkConflicts(cfg)
+					want := "Options " + flagName(a) + " and " + flagName(b) + " are mutually exclusive"
+					if err == nil || err.Error() != want {
+						t.Errorf("checkConflicts() = %v, want %q", err, want)
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// dryRun prints the echo requests of the run, -c of them or 1, as a decoded
+// summary followed by a hexdump of the ICMP bytes, without sending them.
+func (t *target) dryRun() {
+	count := t.cfg.count
+	if count <= 0 {
+		count = 1
+	}
+
+	proto := ipv4.ICMPTypeEcho.Protocol()
+	if t.cfg.isIPv6 {
+		proto = ipv6.ICMPTypeEchoRequest.Protocol()
+	}
+	for i := 0; i < count; i++ {
+		b := t.p.NextRequest()
+		msg, err := icmp.ParseMessage(proto, b)
+		if err != nil {
+			printError(&t.cfg, err)
+			return
+		}
+		fmt.Fprintf(stdout, "%s\n%s", describeRequest(msg, b), hex.Dump(b))
+	}
+}
+
+// describeRequest decodes the header fields of the marshalled ICMP request
+// `b`.
+func describeRequest(msg *icmp.Message, b []byte) string {
+	s := fmt.Sprintf(
+		"%d bytes: type=%d (%v) code=%d checksum=0x%02x%02x",
+		len(b),
+		b[0],
+		typeName(msg.Type),
+		msg.Code,
+		b[2],
+		b[3],
+	)
+	if echo, ok := msg.Body.(*icmp.Echo); ok {
+		s += fmt.Sprintf(" id=%d icmp_seq=%d data=%d bytes", echo.ID, echo.Seq, len(echo.Data))
+	}
+
+	return s
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/temirrr/Pinger/pinger"
+)
+
+// pickVersions chooses the IP version of every destination given without
+// -4 or -6 as pickIPv6 does, true for IPv6. The races of dual-stack hosts run
+// at the same time, at most `cfg.concurrency` of them, so a long target list
+// waits for the slowest one rather than all of them in turn.
+func pickVersions(cfg *config) map[string]bool {
+	versions := make(map[string]bool)
+	if cfg.isIPv4 || cfg.isIPv6 || cfg.numeric || cfg.stampProbe || cfg.maskProbe || cfg.recordRoute || cfg.dryRun {
+		return versions
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, cfg.concurrency)
+	for _, host := range cfg.hosts {
+		// literal IPv6 addresses need no race
+		if strings.Contains(host, ":") {
+			continue
+		}
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			hostCfg := *cfg
+			hostCfg.host = host
+			isIPv6 := pickIPv6(&hostCfg)
+
+			mu.Lock()
+			versions[host] = isIPv6
+			mu.Unlock()
+		}(host)
+	}
+	wg.Wait()
+
+	return versions
+}
+
+// pickIPv6 chooses the IP version for a destination given without -6, happy
+// eyeballs style: a host with addresses of a single version gets that one,
+// for a dual-stack host a single echo request of each version races and the
+// first reply wins. IPv4 is the fallback if neither answers in time, or both
+// runs fail, e.g. without the privileges for raw sockets.
+func pickIPv6(cfg *config) bool {
+	_, err4 := net.ResolveIPAddr("ip4", cfg.host)
+	_, err6 := net.ResolveIPAddr("ip6", cfg.host)
+	if err4 != nil || err6 != nil {
+		return err4 != nil && err6 == nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+	defer cancel()
+
+	winner := make(chan bool, 2)
+	done := make(chan struct{}, 2)
+	racing := 0
+	for _, isIPv6 := range []bool{false, true} {
+		// the callback outlives the iteration, Go 1.21 shares the variable
+		isIPv6 := isIPv6
+		p, err := pinger.New(
+			cfg.host,
+			pinger.WithIPv6(isIPv6),
+			pinger.WithUnprivileged(cfg.udp),
+			pinger.WithSource(cfg.source),
+			pinger.WithCount(1),
+			pinger.WithTimeout(cfg.timeout),
+			pinger.OnRecv(func(pkt pinger.Packet) {
+				if isAnswer(pkt) {
+					winner <- isIPv6
+				}
+			}),
+		)
+		if err != nil {
+			continue
+		}
+		racing++
+		go func() {
+			defer p.Close()
+			p.Run(ctx)
+			done <- struct{}{}
+		}()
+	}
+
+	for racing > 0 {
+		select {
+		case isIPv6 := <-winner:
+			logger.Debug("Dual-stack race won", "host", cfg.host, "ipv6", isIPv6)
+			return isIPv6
+		case <-done:
+			// a run failed, e.g. without privileges, or ended; a reply of
+			// its own is in `winner` by then
+			racing--
+		case <-ctx.Done():
+			return false
+		}
+	}
+	select {
+	case isIPv6 := <-winner:
+		logger.Debug("Dual-stack race won", "host", cfg.host, "ipv6", isIPv6)
+		return isIPv6
+	default:
+		return false
+	}
+}
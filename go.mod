@@ -0,0 +1,9 @@
+module github.com/temirrr/Pinger
+
+go 1.21
+
+require (
+	golang.org/x/net v0.35.0
+	golang.org/x/sys v0.30.0
+	golang.org/x/time v0.10.0
+)
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// histogramBuckets is the rough number of buckets of the RTT histogram.
+const histogramBuckets = 10
+
+// histogramWidth is the length of the longest bar of the RTT histogram.
+const histogramWidth = 40
+
+// histogram renders an ASCII bar chart of the RTT distribution. Bucket bounds
+// are round numbers spanning the observed min to max, e.g. 0-1 ms, 1-2 ms.
+func histogram(rtts []time.Duration) string {
+	if len(rtts) == 0 {
+		return ""
+	}
+
+	min, max := rtts[0], rtts[0]
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+	}
+	step := niceStep((max - min) / histogramBuckets)
+	start := min / step * step
+	counts := make([]int, int((max-start)/step)+1)
+	most := 0
+	for _, rtt := range rtts {
+		i := int((rtt - start) / step)
+		counts[i]++
+		if counts[i] > most {
+			most = counts[i]
+		}
+	}
+
+	var b strings.Builder
+	for i, count := range counts {
+		lo := start + time.Duration(i)*step
+		bar := strings.Repeat("#", (count*histogramWidth+most-1)/most)
+		fmt.Fprintf(
+			&b,
+			"%9.3f - %9.3f ms | %-*s %d\n",
+			durationToMs(lo),
+			durationToMs(lo+step),
+			histogramWidth,
+			bar,
+			count,
+		)
+	}
+
+	return b.String()
+}
+
+// niceStep rounds `d` up to 1, 2 or 5 times a power of ten microseconds, the
+// resolution of the printed bounds.
+func niceStep(d time.Duration) time.Duration {
+	step := time.Microsecond
+	for {
+		for _, m := range []time.Duration{1, 2, 5} {
+			if m*step >= d {
+				return m * step
+			}
+		}
+		step *= 10
+	}
+}
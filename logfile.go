@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stdout receives all output of the CLI, the standard output and with
+// `--log-file` the log as well.
+var stdout io.Writer = os.Stdout
+
+// logFile is an append-only log which is rotated once it would grow beyond
+// `maxSize` bytes: the current file is renamed with a `.1` suffix, replacing
+// an older one, and a new file is started.
+type logFile struct {
+	path    string
+	maxSize int64 // 0 for no rotation
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openLogFile(path string, maxSize int64) (*logFile, error) {
+	l := &logFile{path: path, maxSize: maxSize}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *logFile) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Log file error: %s", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Log file error: %s", err)
+	}
+	l.file, l.size = file, info.Size()
+
+	return nil
+}
+
+// Write appends `b` to the log, rotating it first if it would become too
+// large. If rotating fails, the log keeps growing and rotation is given up,
+// so no output is lost and the error is reported once.
+func (l *logFile) Write(b []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			l.maxSize = 0
+			// `logger` writes to the log as well, which is locked
+			newLogger(os.Stderr).Error(err.Error(), "file", l.path)
+		}
+	}
+	if l.file == nil {
+		return 0, fmt.Errorf("Log file error: %s is closed", l.path)
+	}
+	n, err := l.file.Write(b)
+	l.size += int64(n)
+
+	return n, err
+}
+
+// rotate renames the log with a `.1` suffix and starts a new one. If the
+// rename fails, the old file is opened again for appending.
+func (l *logFile) rotate() error {
+	l.file.Close()
+	l.file = nil
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		if reopenErr := l.open(); reopenErr != nil {
+			return reopenErr
+		}
+		return fmt.Errorf("Log file error: %s", err)
+	}
+
+	return l.open()
+}
+
+// parseSize parses a byte count with an optional K, M or G suffix (powers of
+// 1024), e.g. `10M`.
+func parseSize(s string) (int64, error) {
+	shift := uint(0)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		shift = 10
+	case strings.HasSuffix(s, "M"):
+		shift = 20
+	case strings.HasSuffix(s, "G"):
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("Invalid size: %s", s)
+	}
+
+	return size << shift, nil
+}
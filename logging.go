@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// logLevel is the threshold of `logger`, set by --log-level.
+var logLevel = new(slog.LevelVar)
+
+// stderr receives the diagnostics of `logger`, the standard error and with
+// `--log-file` the log as well.
+var stderr io.Writer = os.Stderr
+
+// logger reports diagnostics like resolve, connection and send errors on
+// `stderr`, apart from the probe results on `stdout`.
+var logger = newLogger(stderr)
+
+func newLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// setLogLevel parses a --log-level: debug, info, warn or error.
+func setLogLevel(s string) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return fmt.Errorf("Invalid log level: %s. Use `debug`, `info`, `warn` or `error`", s)
+	}
+	logLevel.Set(level)
+	return nil
+}
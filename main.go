@@ -1,294 +1,1010 @@
 package main
 
 import (
+	"context"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
-	"math/rand"
-	"net"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
-	"golang.org/x/net/icmp"
-	"golang.org/x/net/ipv4"
-	"golang.org/x/net/ipv6"
+	"github.com/temirrr/Pinger/pinger"
+	"golang.org/x/time/rate"
 )
 
-func parseArgs(hostPtr *string, isIPv6Ptr *bool, ttlPtr *int) {
-	flag.BoolVar(isIPv6Ptr, "6", false, "Set this flag if you want to use IPv6")
-	flag.IntVar(ttlPtr, "t", 100, "Specifies TTL (Time to live).")
-	flag.IntVar(ttlPtr, "ttl", 100, "Specifies TTL (Time to live).")
+// config holds the options given on the command line.
+type config struct {
+	host     string
+	hosts    []string // all destinations, `host` is the one of the current run
+	file     string
+	isIPv4   bool // forced by -4, overriding the guess from the host
+	isIPv6   bool
+	ttl      int
+	tos      int
+	tosStr   string
+	patStr   string
+	count    int
+	once     bool
+	interval time.Duration
+	preload  int
+	adaptive bool
+	jitter   float64
+	retries  int
+	warmup   int
+	rate     float64
+	limiter  *rate.Limiter // shared by the runs of all destinations
+	timeout  time.Duration
+	deadline time.Duration
+	drain    time.Duration
+	size     int
+	maxSize  int
+	pattern  []byte
+	json     bool
+	csv      bool
+	quiet    bool
+	verbose  bool
+	dump     bool
+	flood    bool
+	dotWidth int    // --flood-dots-width, 0 for the terminal width
+	floodCap string // --flood-limit, packets per second or in total
+	stamp    bool
+	colorStr string
+	color    bool
+	slowRTT  time.Duration
+	tmplStr  string
+	template *template.Template // renders reply lines, nil for the default
+
+	hist        bool
+	percentiles bool
+	table       bool // one statistics table for all destinations at the end
+	progress    bool // progress bar of finished probes on stderr
+	showJitter  bool
+	audible     bool
+	audibleLoss bool
+	changesOnly bool
+	changeAfter int
+	reportEvery time.Duration
+	resolve     bool
+	numeric     bool
+	udp         bool
+	localPort   int // source port of datagram sockets, 0 for any
+	proto       int
+	broadcast   bool
+	stampProbe  bool
+	maskProbe   bool
+	id          int
+	startSeq    int
+	source      string
+	pmtudisc    string
+	noFrag      bool
+	recordRoute bool
+	verifySum   bool
+	kernStamps  bool
+	sweepMin    int
+	sweepMax    int
+	sweepStep   int
+	ttlSweep    string // --ttl-sweep, min:max
+	ttlMin      int
+	ttlMax      int
+
+	traceroute  bool
+	maxHops     int
+	mtuDiscover bool
+	dryRun      bool
+	loopback    bool // self-test against the loopback addresses instead of pinging hosts
+	metricsAddr string
+
+	logPath    string
+	logMaxSize string
+	logLevel   string
+
+	deadlineExitFail bool          // exit with 1 on deadline expiry, whatever the replies
+	failAfter        time.Duration // exit once no reply came for this long, 0 to keep going
+	stopOnReply      bool          // exit with the first echo reply
+	deadlineKeep     bool          // -w reports the statistics so far instead of stopping
+
+	ranges      []addrRange // CIDR prefixes among the destinations, expanded into `hosts`
+	concurrency int         // largest number of destinations pinged at the same time
+}
+
+// exitError is the exit code on failures, e.g. invalid options or a host
+// which can't be resolved. Runs exit with `Statistics.ExitCode`.
+const exitError = 2
+
+// minUserInterval is the shortest interval allowed for non-root users.
+const minUserInterval = 200 * time.Millisecond
+
+// minSweepSize is the smallest data size of a sweep, room for the send
+// timestamp.
+const minSweepSize = 8
+
+// maxPayloadSize is the largest ICMP data length fitting into an IPv4 packet.
+const maxPayloadSize = 65535 - 20 - 8
+
+// defineFlags registers the command line options, storing into `cfg`.
+func defineFlags(cfg *config) {
+	flag.BoolVar(&cfg.isIPv4, "4", false, "Force IPv4, even for hosts looking like IPv6 addresses.")
+	flag.BoolVar(&cfg.isIPv4, "ipv4", false, "Force IPv4, even for hosts looking like IPv6 addresses.")
+	flag.BoolVar(&cfg.isIPv6, "6", false, "Set this flag if you want to use IPv6")
+	flag.StringVar(&cfg.file, "F", "", "Read destinations from a file, one per line. They are pinged concurrently.")
+	flag.StringVar(&cfg.file, "file", "", "Read destinations from a file, one per line. They are pinged concurrently.")
+	flag.BoolVar(&cfg.udp, "u", false, "Use unprivileged ICMP datagram sockets instead of raw sockets.")
+	flag.BoolVar(&cfg.udp, "unprivileged", false, "Use unprivileged ICMP datagram sockets instead of raw sockets.")
+	flag.IntVar(&cfg.localPort, "local-port", 0, "Bind the unprivileged socket (-u) to this local port (1-65535), e.g. for firewall rules. It is the ICMP identifier as well.")
+	flag.IntVar(&cfg.id, "id", -1, "ICMP identifier of echo requests (0-65535), random by default.")
+	flag.IntVar(&cfg.startSeq, "start-seq", -1, "Sequence number of the first echo request (0-65535), random by default.")
+	flag.BoolVar(&cfg.stampProbe, "timestamp-probe", false, "Send ICMP Timestamp requests instead of echo requests and estimate the clock offset of the host. IPv4 only.")
+	flag.BoolVar(&cfg.maskProbe, "mask-probe", false, "Send ICMP Address Mask requests instead of echo requests and print the subnet mask the host reports. IPv4 only.")
+	flag.BoolVar(&cfg.broadcast, "b", false, "Allow pinging a broadcast address and list every responding host. Root only.")
+	flag.BoolVar(&cfg.broadcast, "broadcast", false, "Allow pinging a broadcast address and list every responding host. Root only.")
+	flag.StringVar(&cfg.source, "I", "", "Interface name or source address to send echo requests from.")
+	flag.StringVar(&cfg.source, "interface", "", "Interface name or source address to send echo requests from.")
+	flag.IntVar(&cfg.ttl, "t", 100, "Specifies TTL (Time to live).")
+	flag.IntVar(&cfg.ttl, "ttl", 100, "Specifies TTL (Time to live).")
+	flag.StringVar(&cfg.tosStr, "Q", "0", "Type of Service (IPv6 Traffic Class) byte, decimal or 0x prefixed hex.")
+	flag.StringVar(&cfg.tosStr, "tos", "0", "Type of Service (IPv6 Traffic Class) byte, decimal or 0x prefixed hex.")
+	flag.IntVar(&cfg.count, "c", 0, "Stop after sending count echo requests. 0 means no limit.")
+	flag.IntVar(&cfg.count, "count", 0, "Stop after sending count echo requests. 0 means no limit.")
+	flag.BoolVar(&cfg.once, "once", false, "Send a single echo request, print its result only and exit with 0 on reply, 1 otherwise.")
+	flag.DurationVar(&cfg.interval, "i", time.Second, "Wait interval between sending echo requests (e.g. 500ms, 0.2s).")
+	flag.DurationVar(&cfg.interval, "interval", time.Second, "Wait interval between sending echo requests (e.g. 500ms, 0.2s).")
+	flag.BoolVar(&cfg.adaptive, "A", false, "Adaptive ping: the interval follows the RTT, bounded by -i. Root only.")
+	flag.BoolVar(&cfg.adaptive, "adaptive", false, "Adaptive ping: the interval follows the RTT, bounded by -i. Root only.")
+	flag.IntVar(&cfg.warmup, "warmup", 0, "Leave the first N replies out of the RTT statistics.")
+	flag.IntVar(&cfg.retries, "retries", 0, "Resend an echo request without a reply in time up to this many times before it is counted as lost.")
+	flag.Float64Var(&cfg.jitter, "jitter", 0, "Vary every interval randomly by up to +/- this percentage (0-100).")
+	flag.Float64Var(&cfg.rate, "rate", 0, "Send at most this many echo requests per second, in total for all destinations. 0 means no limit.")
+	flag.IntVar(&cfg.preload, "l", 1, "Send preload echo requests at once at startup. Root only above 1.")
+	flag.IntVar(&cfg.preload, "preload", 1, "Send preload echo requests at once at startup. Root only above 1.")
+	flag.DurationVar(&cfg.timeout, "W", 2*time.Second, "Time to wait for a reply before the echo request is counted as lost.")
+	flag.DurationVar(&cfg.timeout, "timeout", 2*time.Second, "Time to wait for a reply before the echo request is counted as lost.")
+	flag.DurationVar(&cfg.deadline, "w", 0, "Stop after this duration, however many echo requests were sent. 0 means no deadline.")
+	flag.DurationVar(&cfg.deadline, "deadline", 0, "Stop after this duration, however many echo requests were sent. 0 means no deadline.")
+	flag.DurationVar(&cfg.drain, "drain", -1, "Time to wait at the end for replies to echo requests still in flight. 0 disables it, negative waits as long as the timeout.")
+	flag.DurationVar(&cfg.failAfter, "fail-after", 0, "Exit with 1 once no echo reply arrived for this duration, e.g. 30s. 0 never gives up.")
+	flag.BoolVar(&cfg.stopOnReply, "stop-on-first-reply", false, "Exit with 0 as soon as the first echo reply arrives, for health checks. -c and -w still end the run earlier without one.")
+	flag.BoolVar(&cfg.deadlineKeep, "deadline-then-keep", false, "Turn -w into a report boundary: print the statistics so far when it expires, then keep pinging and mark later probes.")
+	flag.BoolVar(&cfg.deadlineExitFail, "deadline-exit-nonzero", false, "Exit with 1 when the deadline expires, even if replies arrived, for runs which have to complete their count in time.")
+	flag.StringVar(&cfg.pmtudisc, "M", "dont", "Path MTU discovery strategy: `do` sets the Don't Fragment bit, dont leaves fragmentation to the system.")
+	flag.BoolVar(&cfg.noFrag, "dont-fragment", false, "Set the Don't Fragment bit, same as -M do.")
+	flag.BoolVar(&cfg.recordRoute, "R", false, "Record Route: ask routers to record their addresses into echo requests and print the route of replies. At most 9 hops fit. IPv4 over raw sockets only.")
+	flag.BoolVar(&cfg.recordRoute, "record-route", false, "Record Route: ask routers to record their addresses into echo requests and print the route of replies. At most 9 hops fit. IPv4 over raw sockets only.")
+	flag.IntVar(&cfg.size, "s", 56, "Specifies the number of data bytes to be sent.")
+	flag.IntVar(&cfg.size, "size", 56, "Specifies the number of data bytes to be sent.")
+	flag.IntVar(&cfg.maxSize, "max-size", maxPayloadSize, "Reject data sizes (-s, --sweep-max) above this.")
+	flag.IntVar(&cfg.sweepMin, "sweep-min", minSweepSize, "Data size of the first echo request in sweep mode.")
+	flag.IntVar(&cfg.sweepMax, "sweep-max", 0, "Sweep mode: grow the data size of every echo request up to this size, then stop.")
+	flag.IntVar(&cfg.sweepStep, "sweep-step", 1, "Growth of the data size per echo request in sweep mode.")
+	flag.StringVar(&cfg.ttlSweep, "ttl-sweep", "", "Cycle the outgoing TTL of successive echo requests over min:max, e.g. 1:10.")
+	flag.StringVar(&cfg.patStr, "p", "", "Hex byte pattern to fill the echo data with, e.g. ff00.")
+	flag.StringVar(&cfg.patStr, "pattern", "", "Hex byte pattern to fill the echo data with, e.g. ff00.")
+	flag.BoolVar(&cfg.flood, "f", false, "Flood ping: send echo requests as fast as replies come back. Root only.")
+	flag.BoolVar(&cfg.flood, "flood", false, "Flood ping: send echo requests as fast as replies come back. Root only.")
+	flag.IntVar(&cfg.dotWidth, "flood-dots-width", 0, "Wrap flood dots after this many columns. By default they follow the terminal width.")
+	flag.StringVar(&cfg.floodCap, "flood-limit", "", "Safety cap applying even to flood ping: N/s echo requests per second or N in total.")
+	flag.BoolVar(&cfg.stamp, "D", false, "Prefix every line with the Unix time.")
+	flag.BoolVar(&cfg.stamp, "timestamp", false, "Prefix every line with the Unix time.")
+	flag.StringVar(&cfg.colorStr, "color", "auto", "Color replies, losses and slow replies: `auto` (only on terminals), always or never.")
+	flag.DurationVar(&cfg.slowRTT, "color-threshold", 100*time.Millisecond, "Replies slower than this are colored as slow.")
+	flag.StringVar(&cfg.tmplStr, "output-template", "", "Go text/template rendering reply lines, e.g. '{{.Seq}} {{.RTTMs}}'. Fields: Host, IP, From, Seq, TTL, RTT, RTTMs, Bytes, Dup.")
+	flag.StringVar(&cfg.tmplStr, "format", "", "Go text/template rendering reply lines, e.g. '{{.Seq}} {{.RTTMs}}'. Fields: Host, IP, From, Seq, TTL, RTT, RTTMs, Bytes, Dup.")
+	flag.BoolVar(&cfg.showJitter, "show-jitter", false, "Print the RTT difference to the previous reply on every reply line.")
+	flag.BoolVar(&cfg.progress, "progress", false, "Show a progress bar of the finished probes of all destinations on stderr, if it is a terminal. Needs -c.")
+	flag.BoolVar(&cfg.table, "table", false, "Print the final statistics of all destinations as one aligned table, fping style.")
+	flag.BoolVar(&cfg.percentiles, "percentiles", false, "Add the 50th, 95th and 99th percentile of the round trip times to the final statistics.")
+	flag.BoolVar(&cfg.hist, "histogram", false, "Add a histogram of the round trip times to the final statistics.")
+	flag.BoolVar(&cfg.audible, "audible", false, "Ring the terminal bell on every echo reply.")
+	flag.BoolVar(&cfg.audibleLoss, "audible-loss", false, "Ring the terminal bell on every lost echo request.")
+	flag.BoolVar(&cfg.changesOnly, "changes-only", false, "Print only when the host goes up or down instead of every probe.")
+	flag.IntVar(&cfg.changeAfter, "change-threshold", 3, "Number of consecutive probe results needed to flip the host state.")
+	flag.DurationVar(&cfg.reportEvery, "report-every", 0, "Print interim statistics every given duration (e.g. 1m). 0 disables them.")
+	flag.BoolVar(&cfg.verbose, "v", false, "Verbose output. Also print received messages not concerning our echo requests.")
+	flag.BoolVar(&cfg.verbose, "verbose", false, "Verbose output. Also print received messages not concerning our echo requests.")
+	flag.BoolVar(&cfg.dump, "dump", false, "Print a hexdump of every received ICMP message after its line.")
+	flag.BoolVar(&cfg.quiet, "q", false, "Quiet output. Only the header and the final statistics are printed.")
+	flag.BoolVar(&cfg.quiet, "quiet", false, "Quiet output. Only the header and the final statistics are printed.")
+	flag.BoolVar(&cfg.resolve, "a", false, "Resolve host names of responding addresses.")
+	flag.BoolVar(&cfg.resolve, "resolve", false, "Resolve host names of responding addresses.")
+	flag.BoolVar(&cfg.numeric, "n", false, "Numeric output only. The host must be a literal IP address, no DNS queries are made.")
+	flag.BoolVar(&cfg.numeric, "numeric", false, "Numeric output only. The host must be a literal IP address, no DNS queries are made.")
+	flag.BoolVar(&cfg.traceroute, "traceroute", false, "Trace the route to the host by sending echo requests with growing TTL.")
+	flag.IntVar(&cfg.maxHops, "max-hops", 30, "Largest TTL used in traceroute mode.")
+	flag.BoolVar(&cfg.mtuDiscover, "mtu-discover", false, "Discover the path MTU to the host and exit.")
+	flag.BoolVar(&cfg.loopback, "loopback", false, "Self-test: ping the IPv4 and IPv6 loopback addresses and exit with 0 if they answer sanely.")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "Print the echo requests as hexdump instead of sending them. Needs no privileges.")
+	flag.IntVar(&cfg.concurrency, "concurrency", 256, "Largest number of destinations pinged at the same time.")
+	flag.StringVar(&cfg.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9115.")
+	flag.StringVar(&cfg.logPath, "log-file", "", "Also write all output to this file.")
+	flag.StringVar(&cfg.logMaxSize, "log-max-size", "0", "Rotate the log file once it would grow beyond this size, e.g. 10M. 0 disables rotation.")
+	flag.StringVar(&cfg.logLevel, "log-level", "info", "Least severe diagnostics logged to stderr: debug, info, warn or error.")
+	flag.IntVar(&cfg.proto, "proto", 0, "Advanced: protocol number to parse received messages with, 1 (ICMP) or 58 (ICMPv6). Defaults to that of the IP version.")
+	flag.BoolVar(&cfg.verifySum, "verify-checksum", false, "Count IPv4 echo replies with a wrong ICMP checksum as corrupted.")
+	flag.BoolVar(&cfg.kernStamps, "kernel-timestamps", false, "Take receive times from the kernel or network card (SO_TIMESTAMPING) for more accurate RTTs. Linux only.")
+	flag.BoolVar(&cfg.json, "json", false, "Print one JSON object per line instead of human readable output.")
+	flag.BoolVar(&cfg.csv, "csv", false, "Print one CSV row per probe instead of human readable output.")
+}
+
+func parseArgs(cfg *config) {
+	defineFlags(cfg)
 	Usage := func() {
 		fmt.Fprintf(os.Stderr, "Usage : %s:\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 
-	*hostPtr = flag.Arg(0)
-	if flag.NArg() == 0 {
+	if err := setLogLevel(cfg.logLevel); err != nil {
+		fmt.Printf("%s.\n", err)
+		os.Exit(exitError)
+	}
+	if cfg.logPath != "" {
+		maxSize, err := parseSize(cfg.logMaxSize)
+		if err != nil {
+			fmt.Printf("Invalid log size: %s. Size must be a byte count, optionally with a K, M or G suffix.\n", cfg.logMaxSize)
+			os.Exit(exitError)
+		}
+		log, err := openLogFile(cfg.logPath, maxSize)
+		if err != nil {
+			fmt.Printf("%s.\n", err)
+			os.Exit(exitError)
+		}
+		stdout = io.MultiWriter(os.Stdout, log)
+		stderr = io.MultiWriter(os.Stderr, log)
+		logger = newLogger(stderr)
+	}
+
+	cfg.hosts = flag.Args()
+	if cfg.file != "" {
+		hosts, err := readHosts(cfg.file)
+		if err != nil {
+			logger.Error(err.Error(), "file", cfg.file)
+			os.Exit(exitError)
+		}
+		cfg.hosts = append(cfg.hosts, hosts...)
+	}
+	var hosts []string
+	for _, host := range cfg.hosts {
+		if !strings.Contains(host, "/") {
+			hosts = append(hosts, host)
+			continue
+		}
+		r, err := expandRange(host)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid range: %s. Ranges must be CIDR prefixes of at most %d addresses.\n", host, 1<<maxRangeBits)
+			os.Exit(exitError)
+		}
+		cfg.ranges = append(cfg.ranges, r)
+		hosts = append(hosts, r.addrs...)
+	}
+	cfg.hosts = hosts
+	if len(cfg.hosts) > maxDestinations {
+		fmt.Fprintf(stdout, "Invalid destinations: %d. At most %d destinations can be pinged at once.\n", len(cfg.hosts), maxDestinations)
+		os.Exit(exitError)
+	}
+	if cfg.loopback && len(cfg.hosts) > 0 {
+		fmt.Fprintf(stdout, "Option --loopback takes no destination.\n")
+		os.Exit(exitError)
+	}
+	if len(cfg.hosts) == 0 && !cfg.loopback {
 		Usage()
-		os.Exit(1)
+		os.Exit(exitError)
+	}
+	if cfg.concurrency < 1 {
+		fmt.Fprintf(stdout, "Invalid concurrency: %d. Concurrency must be positive.\n", cfg.concurrency)
+		os.Exit(exitError)
+	}
+	if len(cfg.hosts) > 1 && (cfg.flood || cfg.traceroute) {
+		fmt.Fprintf(stdout, "Flood ping and traceroute are only supported for a single destination.\n")
+		os.Exit(exitError)
 	}
-}
 
-func printArgs(hostPtr *string, isIPv6Ptr *bool, ttlPtr *int) {
-	ipVersionStr := "IPv4"
-	if *isIPv6Ptr {
-		ipVersionStr = "IPv6"
+	if err := checkConflicts(cfg); err != nil {
+		fmt.Fprintf(stdout, "%s.\n", err)
+		os.Exit(exitError)
+	}
+	if cfg.once {
+		cfg.count = 1
+	}
+	if cfg.flood {
+		if os.Geteuid() != 0 {
+			fmt.Fprintf(stdout, "Flood ping is only permitted for root.\n")
+			os.Exit(exitError)
+		}
+		cfg.interval = 0
+	}
+	if cfg.dotWidth < 0 {
+		fmt.Fprintf(stdout, "Invalid flood dots width: %d. Width can not be negative.\n", cfg.dotWidth)
+		os.Exit(exitError)
+	}
+	if cfg.interval < 0 {
+		fmt.Fprintf(stdout, "Invalid interval: %s. Interval can not be negative.\n", cfg.interval)
+		os.Exit(exitError)
+	}
+	if cfg.interval < minUserInterval && os.Geteuid() != 0 {
+		fmt.Fprintf(stdout,
+			"Invalid interval: %s. Only root can set interval less than %s.\n",
+			cfg.interval,
+			minUserInterval,
+		)
+		os.Exit(exitError)
+	}
+	if cfg.id != -1 {
+		if cfg.id < 0 || cfg.id > 0xffff {
+			fmt.Fprintf(stdout, "Invalid identifier: %d. Identifier must be in range 0-65535.\n", cfg.id)
+			os.Exit(exitError)
+		}
+		if cfg.udp || len(cfg.hosts) > 1 {
+			fmt.Fprintf(stdout, "Invalid identifier: %d. Identifiers can only be set for a single destination over raw sockets.\n", cfg.id)
+			os.Exit(exitError)
+		}
+	}
+	if cfg.proto != 0 && cfg.proto != 1 && cfg.proto != 58 {
+		fmt.Fprintf(stdout, "Invalid protocol: %d. Protocol must be 1 (ICMP) or 58 (ICMPv6).\n", cfg.proto)
+		os.Exit(exitError)
+	}
+	if cfg.startSeq != -1 && (cfg.startSeq < 0 || cfg.startSeq > 0xffff) {
+		fmt.Fprintf(stdout, "Invalid start sequence: %d. Sequence numbers must be in range 0-65535.\n", cfg.startSeq)
+		os.Exit(exitError)
+	}
+	if cfg.localPort != 0 {
+		if cfg.localPort < 1 || cfg.localPort > 0xffff {
+			fmt.Fprintf(stdout, "Invalid local port: %d. Ports must be in range 1-65535.\n", cfg.localPort)
+			os.Exit(exitError)
+		}
+		if !cfg.udp {
+			fmt.Fprintf(stdout, "Invalid local port: %d. Only unprivileged sockets (-u) have ports.\n", cfg.localPort)
+			os.Exit(exitError)
+		}
+	}
+	if cfg.stampProbe && (cfg.isIPv6 || cfg.udp) {
+		fmt.Fprintf(stdout, "Timestamp probes are only supported for IPv4 over raw sockets.\n")
+		os.Exit(exitError)
+	}
+	if cfg.maskProbe && (cfg.isIPv6 || cfg.udp) {
+		fmt.Fprintf(stdout, "Address mask probes are only supported for IPv4 over raw sockets.\n")
+		os.Exit(exitError)
+	}
+	if cfg.recordRoute && (cfg.isIPv6 || cfg.udp) {
+		fmt.Fprintf(stdout, "Record Route is only supported for IPv4 over raw sockets.\n")
+		os.Exit(exitError)
+	}
+	if cfg.broadcast {
+		if os.Geteuid() != 0 {
+			fmt.Fprintf(stdout, "Broadcast ping is only permitted for root.\n")
+			os.Exit(exitError)
+		}
+		logger.Warn("Pinging broadcast address, every host on the network may answer")
+	}
+	if cfg.adaptive && os.Geteuid() != 0 {
+		fmt.Fprintf(stdout, "Adaptive ping is only permitted for root.\n")
+		os.Exit(exitError)
+	}
+	if cfg.jitter < 0 || cfg.jitter > 100 {
+		fmt.Fprintf(stdout, "Invalid jitter: %g. Jitter must be in range 0-100.\n", cfg.jitter)
+		os.Exit(exitError)
+	}
+	if cfg.warmup < 0 {
+		fmt.Fprintf(stdout, "Invalid warmup: %d. Warmup can not be negative.\n", cfg.warmup)
+		os.Exit(exitError)
+	}
+	if cfg.retries < 0 {
+		fmt.Fprintf(stdout, "Invalid retries: %d. Retries can not be negative.\n", cfg.retries)
+		os.Exit(exitError)
+	}
+	if cfg.rate < 0 {
+		fmt.Fprintf(stdout, "Invalid rate: %g. Rate can not be negative.\n", cfg.rate)
+		os.Exit(exitError)
+	}
+	if cfg.ttlSweep != "" {
+		min, max, err := parseTTLSweep(cfg.ttlSweep)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid TTL sweep: %s. Sweep must be min:max with TTLs in range 1-255, the minimum not above the maximum.\n", cfg.ttlSweep)
+			os.Exit(exitError)
+		}
+		cfg.ttlMin, cfg.ttlMax = min, max
+	}
+	if cfg.floodCap != "" {
+		pps, total, err := parseFloodLimit(cfg.floodCap)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid flood limit: %s. Limit must be a positive number, followed by /s for a rate.\n", cfg.floodCap)
+			os.Exit(exitError)
+		}
+		if pps > 0 && (cfg.rate == 0 || pps < cfg.rate) {
+			cfg.rate = pps
+		}
+		if total > 0 && (cfg.count == 0 || total < cfg.count) {
+			cfg.count = total
+		}
+	}
+	if cfg.rate > 0 {
+		cfg.limiter = rate.NewLimiter(rate.Limit(cfg.rate), 1)
+	}
+	if cfg.preload < 1 {
+		fmt.Fprintf(stdout, "Invalid preload: %d. Preload must be positive.\n", cfg.preload)
+		os.Exit(exitError)
+	}
+	if cfg.preload > 1 && os.Geteuid() != 0 {
+		fmt.Fprintf(stdout, "Invalid preload: %d. Only root can set preload greater than 1.\n", cfg.preload)
+		os.Exit(exitError)
+	}
+	if cfg.timeout <= 0 {
+		fmt.Fprintf(stdout, "Invalid timeout: %s. Timeout must be positive.\n", cfg.timeout)
+		os.Exit(exitError)
+	}
+	if cfg.failAfter < 0 {
+		fmt.Fprintf(stdout, "Invalid fail-after: %s. Duration can not be negative.\n", cfg.failAfter)
+		os.Exit(exitError)
+	}
+	if cfg.deadline < 0 {
+		fmt.Fprintf(stdout, "Invalid deadline: %s. Deadline can not be negative.\n", cfg.deadline)
+		os.Exit(exitError)
+	}
+	if cfg.deadlineKeep && cfg.deadline == 0 {
+		fmt.Fprintf(stdout, "Invalid deadline: %s. Option --deadline-then-keep needs a deadline to report at.\n", cfg.deadline)
+		os.Exit(exitError)
+	}
+	switch cfg.pmtudisc {
+	case "do":
+		cfg.noFrag = true
+	case "dont":
+	default:
+		fmt.Fprintf(stdout, "Invalid path MTU discovery strategy: %s. Use `do` or `dont`.\n", cfg.pmtudisc)
+		os.Exit(exitError)
+	}
+	tos, err := parseTOS(cfg.tosStr)
+	if err != nil {
+		fmt.Fprintf(stdout, "Invalid TOS: %s. TOS must be in range 0-255, decimal or 0x prefixed hex.\n", cfg.tosStr)
+		os.Exit(exitError)
+	}
+	cfg.tos = tos
+	if cfg.patStr != "" {
+		pattern, err := hex.DecodeString(cfg.patStr)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid pattern: %s. Pattern must be an even number of hex digits.\n", cfg.patStr)
+			os.Exit(exitError)
+		}
+		cfg.pattern = pattern
+	}
+	if cfg.tmplStr != "" {
+		tmpl, err := parseOutputTemplate(cfg.tmplStr)
+		if err != nil {
+			fmt.Fprintf(stdout, "Invalid output template: %v.\n", err)
+			os.Exit(exitError)
+		}
+		cfg.template = tmpl
+	}
+	if cfg.reportEvery < 0 {
+		fmt.Fprintf(stdout, "Invalid report interval: %s. Report interval can not be negative.\n", cfg.reportEvery)
+		os.Exit(exitError)
+	}
+	color, err := useColor(cfg.colorStr)
+	if err != nil {
+		fmt.Fprintf(stdout, "%s.\n", err)
+		os.Exit(exitError)
+	}
+	cfg.color = color
+	if cfg.changeAfter < 1 {
+		fmt.Fprintf(stdout, "Invalid change threshold: %d. Threshold must be positive.\n", cfg.changeAfter)
+		os.Exit(exitError)
+	}
+	if cfg.maxHops < 1 || cfg.maxHops > 255 {
+		fmt.Fprintf(stdout, "Invalid max hops: %d. Max hops must be in range 1-255.\n", cfg.maxHops)
+		os.Exit(exitError)
+	}
+	if cfg.maxSize < 0 || cfg.maxSize > maxPayloadSize {
+		fmt.Fprintf(stdout, "Invalid maximum size: %d. Maximum must be in range 0-%d.\n", cfg.maxSize, maxPayloadSize)
+		os.Exit(exitError)
+	}
+	if cfg.size < 0 || cfg.size > cfg.maxSize {
+		fmt.Fprintf(stdout, "Invalid packet size: %d. Size must be in range 0-%d.\n", cfg.size, cfg.maxSize)
+		os.Exit(exitError)
+	}
+	if cfg.sweepMax > 0 {
+		if cfg.sweepMin < minSweepSize || cfg.sweepMin > cfg.sweepMax || cfg.sweepMax > cfg.maxSize {
+			fmt.Fprintf(stdout,
+				"Invalid sweep: %d-%d. Sizes must be in range %d-%d, the minimum not above the maximum.\n",
+				cfg.sweepMin,
+				cfg.sweepMax,
+				minSweepSize,
+				cfg.maxSize,
+			)
+			os.Exit(exitError)
+		}
+		if cfg.sweepStep < 1 {
+			fmt.Fprintf(stdout, "Invalid sweep step: %d. Step must be positive.\n", cfg.sweepStep)
+			os.Exit(exitError)
+		}
 	}
-	fmt.Printf(
-		"PING %s, IP version: %s, ttl: %d.\n",
-		*hostPtr,
-		ipVersionStr,
-		*ttlPtr,
-	)
 }
 
-func timeToBytes(t time.Time) []byte {
-	bytes := make([]byte, 8)
-	nsecs := t.UnixNano()
-	for i := 0; i < 8; i++ {
-		bytes[i] = byte(0xff & (nsecs >> ((7 - i) * 8)))
+// parseTOS parses a TOS byte given in decimal or as 0x prefixed hex.
+func parseTOS(s string) (int, error) {
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s, base = s[2:], 16
 	}
+	tos, err := strconv.ParseUint(s, base, 8)
+	return int(tos), err
+}
 
-	return bytes
+// parseFloodLimit parses a --flood-limit: `N/s` caps the rate at N echo
+// requests per second, a bare `N` their total number.
+func parseFloodLimit(s string) (pps float64, total int, err error) {
+	if n, ok := strings.CutSuffix(s, "/s"); ok {
+		pps, err = strconv.ParseFloat(n, 64)
+		if err == nil && !(pps > 0) {
+			err = errors.New("not positive")
+		}
+		return pps, 0, err
+	}
+	total, err = strconv.Atoi(s)
+	if err == nil && total <= 0 {
+		err = errors.New("not positive")
+	}
+	return 0, total, err
 }
 
-func bytesToTime(bytes []byte) time.Time {
-	nsecs := int64(0)
-	for i := 0; i < 8; i++ {
-		nsecs += int64(bytes[i]) << ((7 - i) * 8)
+// parseTTLSweep parses a --ttl-sweep of the form `min:max`.
+func parseTTLSweep(s string) (min, max int, err error) {
+	lo, hi, ok := strings.Cut(s, ":")
+	if !ok {
+		return 0, 0, errors.New("missing colon")
+	}
+	if min, err = strconv.Atoi(lo); err != nil {
+		return 0, 0, err
+	}
+	if max, err = strconv.Atoi(hi); err != nil {
+		return 0, 0, err
+	}
+	if min < 1 || max > 255 || min > max {
+		return 0, 0, errors.New("out of range")
 	}
+	return min, max, nil
+}
 
-	return time.Unix(nsecs/1000000000, nsecs%1000000000)
+func printArgs(cfg *config) {
+	ipVersionStr := "IPv4"
+	if cfg.isIPv6 {
+		ipVersionStr = "IPv6"
+	}
+	fmt.Fprintf(stdout,
+		"PING %s, IP version: %s, ttl: %d.\n",
+		cfg.host,
+		ipVersionStr,
+		cfg.ttl,
+	)
 }
 
-// PingProc is a client's ping process.
-type PingProc struct {
-	id       int
-	seqnum   int
-	dst      net.IPAddr
-	isIPv6   bool
-	ttl      int
-	rttLimit time.Duration
-	interval time.Duration // time between echo signals
+// printError logs an error of a run, labeled with its destination.
+func printError(cfg *config, err error) {
+	logger.Error(err.Error(), "host", cfg.host)
 }
 
-func newPingProc(dstIP net.IPAddr, isIPv6 bool, ttl int) *PingProc {
-	// ensuring new seed value everytime
-	rand.Seed(time.Now().UnixNano())
+// target is a destination ready to be pinged.
+type target struct {
+	cfg   config
+	p     *pinger.Pinger
+	out   *output
+	alive bool               // whether any echo reply arrived
+	stats *pinger.Statistics // of the run, nil if it failed
+}
 
-	return &PingProc{
-		id:       rand.Intn(1 << 16),
-		seqnum:   rand.Intn(1 << 16),
-		dst:      dstIP,
-		isIPv6:   isIPv6,
-		ttl:      ttl,
-		rttLimit: 2 * time.Second,
-		interval: time.Second,
-	}
+// sharedConns opens a single connection per IP version for all
+// destinations, when they are first needed.
+type sharedConns struct {
+	cfg   *config
+	conns map[bool]*pinger.SharedConn // by IP version, true for IPv6
 }
 
-func (p *PingProc) getConnection(network, address string) *icmp.PacketConn {
-	conn, err := icmp.ListenPacket(network, address)
+func (s *sharedConns) get(isIPv6 bool) (*pinger.SharedConn, error) {
+	if conn, ok := s.conns[isIPv6]; ok {
+		return conn, nil
+	}
+	conn, err := pinger.NewSharedConn(
+		isIPv6,
+		pinger.WithUnprivileged(s.cfg.udp),
+		pinger.WithLocalPort(s.cfg.localPort),
+		pinger.WithTTL(s.cfg.ttl),
+		pinger.WithTOS(s.cfg.tos),
+		pinger.WithDontFragment(s.cfg.noFrag),
+		pinger.WithProtocol(s.cfg.proto),
+		pinger.WithKernelTimestamps(s.cfg.kernStamps),
+	)
 	if err != nil {
-		fmt.Printf("Opening connection error: %s.\n", err)
-		os.Exit(1)
+		return nil, err
 	}
+	s.conns[isIPv6] = conn
+	return conn, nil
+}
 
-	if !p.isIPv6 {
-		conn.IPv4PacketConn().SetControlMessage(ipv4.FlagTTL, true)
-		conn.IPv4PacketConn().SetTTL(p.ttl)
-	} else {
-		conn.IPv6PacketConn().SetControlMessage(ipv6.FlagHopLimit, true)
-		conn.IPv6PacketConn().SetHopLimit(p.ttl)
+func (s *sharedConns) close() {
+	for _, conn := range s.conns {
+		conn.Close()
 	}
-
-	return conn
 }
 
-func (p *PingProc) sendEcho(cn *icmp.PacketConn) error {
-	var msgType icmp.Type
-	if !p.isIPv6 {
-		msgType = ipv4.ICMPTypeEcho
-	} else {
-		msgType = ipv6.ICMPTypeEchoRequest
+// newTarget resolves the destination `cfg.host` and sets up its output.
+// With `conns` it pings through their connection of its IP version.
+func newTarget(cfg config, conns *sharedConns) (*target, error) {
+	// -4 forces IPv4, whatever the host looks like
+	if !cfg.isIPv4 && strings.Index(cfg.host, ":") != -1 {
+		cfg.isIPv6 = true
 	}
-	p.seqnum++
-	t := timeToBytes(time.Now())
-
-	// checksum is calculated by `Marshal` method
-	bytes, _ := (&icmp.Message{
-		Type: msgType,
-		Code: 0,
-		Body: &icmp.Echo{
-			ID:   p.id,
-			Seq:  p.seqnum,
-			Data: t,
-		},
-	}).Marshal(nil)
-
-	if _, err := cn.WriteTo(bytes, &p.dst); err != nil {
-		sendErr := fmt.Errorf("Send echo error: %s", err)
-		return sendErr
+	if cfg.proto != 0 && (cfg.proto == 58) != cfg.isIPv6 {
+		logger.Warn("Parsing messages with the protocol number of the other IP version", "host", cfg.host, "proto", cfg.proto)
 	}
 
-	return nil
-}
+	human := !cfg.json && !cfg.csv
+	if human && !cfg.traceroute && !cfg.mtuDiscover && !cfg.once {
+		printArgs(&cfg)
+	}
 
-type recvResult struct {
-	msg *icmp.Message
-	ttl int
-	err error
-}
+	out := &output{
+		isIPv6: cfg.isIPv6,
+		quiet:  cfg.quiet,
+		flood:  cfg.flood && human,
 
-func (p *PingProc) recvEchoReply(cn *icmp.PacketConn, ch chan recvResult) {
-	for {
-		bytes := make([]byte, 512)
-
-		var ttl int
-		var err error
-		if !p.isIPv6 {
-			var cm *ipv4.ControlMessage
-			_, cm, _, err = cn.IPv4PacketConn().ReadFrom(bytes)
-			if err != nil {
-				recvErr := fmt.Errorf("Send echo error: %s", err)
-				ch <- recvResult{nil, -1, recvErr}
-				return
-			}
-			if cm != nil {
-				ttl = cm.TTL
-			}
-		} else {
-			var cm *ipv6.ControlMessage
-			_, cm, _, err = cn.IPv6PacketConn().ReadFrom(bytes)
-			if err != nil {
-				recvErr := fmt.Errorf("Send echo error: %s", err)
-				ch <- recvResult{nil, -1, recvErr}
-				return
-			}
-			if cm != nil {
-				ttl = cm.HopLimit
-			}
-		}
+		bellOnReply: cfg.audible && human,
+		bellOnLoss:  cfg.audibleLoss && human,
 
-		var msg *icmp.Message
-		protoNum := ipv4.ICMPTypeEchoReply.Protocol()
-		if p.isIPv6 {
-			protoNum = ipv6.ICMPTypeEchoReply.Protocol()
+		dump: cfg.dump && human,
+	}
+	if out.flood {
+		// dots need a terminal to be erased, pipes get a line per event
+		switch {
+		case cfg.dotWidth > 0:
+			out.floodWidth = cfg.dotWidth
+		case isTerminal(os.Stdout):
+			out.floodWidth = terminalWidth(os.Stdout)
+		default:
+			out.flood = false
 		}
-		if msg, err = icmp.ParseMessage(protoNum, bytes); err != nil {
-			recvErr := fmt.Errorf("Send echo error: %s", err)
-			ch <- recvResult{nil, -1, recvErr}
-			return
+	}
+	if cfg.changesOnly {
+		out.changes = &reachability{threshold: cfg.changeAfter}
+	}
+	if cfg.metricsAddr != "" {
+		out.metrics = newMetrics(cfg.host)
+	}
+	// with --deadline-then-keep -w is no context deadline, the run reports
+	// at it by itself
+	var softDeadline time.Duration
+	if cfg.deadlineKeep {
+		softDeadline = cfg.deadline
+	}
+	opts := []pinger.Option{
+		pinger.WithIPv6(cfg.isIPv6),
+		pinger.WithProtocol(cfg.proto),
+		pinger.WithUnprivileged(cfg.udp),
+		pinger.WithLocalPort(cfg.localPort),
+		pinger.WithBroadcast(cfg.broadcast),
+		pinger.WithTimestampProbe(cfg.stampProbe),
+		pinger.WithMaskProbe(cfg.maskProbe),
+		pinger.WithNumeric(cfg.numeric),
+		pinger.WithSource(cfg.source),
+		pinger.WithTTL(cfg.ttl),
+		pinger.WithTOS(cfg.tos),
+		pinger.WithDontFragment(cfg.noFrag),
+		pinger.WithRecordRoute(cfg.recordRoute),
+		pinger.WithStopOnReply(cfg.stopOnReply),
+		pinger.WithVerifyChecksum(cfg.verifySum),
+		pinger.WithKernelTimestamps(cfg.kernStamps),
+		pinger.WithCount(cfg.count),
+		pinger.WithInterval(cfg.interval),
+		pinger.WithPreload(cfg.preload),
+		pinger.WithAdaptive(cfg.adaptive),
+		pinger.WithJitter(cfg.jitter),
+		pinger.WithRateLimiter(cfg.limiter),
+		pinger.WithRetries(cfg.retries),
+		pinger.WithWarmup(cfg.warmup),
+		pinger.WithTimeout(cfg.timeout),
+		pinger.WithDrain(cfg.drain),
+		pinger.WithSize(cfg.size),
+		pinger.WithPattern(cfg.pattern),
+		pinger.WithReportInterval(cfg.reportEvery),
+		pinger.WithSoftDeadline(softDeadline),
+		pinger.WithMaxHops(cfg.maxHops),
+		pinger.OnSend(out.onSend),
+		pinger.OnRecv(out.onRecv),
+		pinger.OnTimeout(out.onTimeout),
+		pinger.OnError(out.onError),
+		pinger.OnHop(out.onHop),
+		pinger.OnReport(out.onReport),
+		pinger.OnDeadline(out.onDeadline),
+	}
+	if cfg.verbose {
+		opts = append(opts, pinger.OnForeign(out.onForeign))
+	}
+	if cfg.id != -1 {
+		opts = append(opts, pinger.WithID(cfg.id))
+	}
+	if cfg.startSeq != -1 {
+		opts = append(opts, pinger.WithStartSeq(cfg.startSeq))
+	}
+	if cfg.sweepMax > 0 {
+		opts = append(opts, pinger.WithSweep(cfg.sweepMin, cfg.sweepMax, cfg.sweepStep))
+	}
+	if cfg.ttlMax > 0 {
+		opts = append(opts, pinger.WithTTLSweep(cfg.ttlMin, cfg.ttlMax))
+	}
+	if conns != nil {
+		conn, err := conns.get(cfg.isIPv6)
+		if err != nil {
+			return nil, err
 		}
-
-		ch <- recvResult{msg, ttl, nil}
+		opts = append(opts, pinger.WithSharedConn(conn))
 	}
+	p, err := pinger.New(cfg.host, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLinkMTU(cfg, p); err != nil {
+		p.Close()
+		return nil, err
+	}
+	logger.Debug("Destination resolved", "host", cfg.host, "ip", p.IPAddr().IP)
+
+	return &target{cfg: cfg, p: p, out: out}, nil
 }
 
-func (p *PingProc) handleEchoReply(msg *icmp.Message, ttl int) {
-	var rtt time.Duration
-	switch body := msg.Body.(type) {
-	case *icmp.Echo:
-		if body.ID == p.id && body.Seq == p.seqnum {
-			rtt = time.Since(bytesToTime(body.Data))
+// setFormat sets up the formatter of the output. It writes to `stdout` as it
+// is by then, so it follows enableProgress.
+func (t *target) setFormat() {
+	cfg, out := &t.cfg, t.out
+	ip := t.p.IPAddr().IP
+	switch {
+	case cfg.json:
+		out.format = &jsonFormatter{host: cfg.host, ip: ip, percentiles: cfg.percentiles}
+	case cfg.csv:
+		out.format = newCSVFormatter(stdout, cfg.host, ip, cfg.deadlineKeep)
+	default:
+		hf := &humanFormatter{
+			host:    cfg.host,
+			ip:      ip,
+			stamp:   cfg.stamp,
+			color:   cfg.color,
+			slowRTT: cfg.slowRTT,
+			hist:    cfg.hist,
+			jitter:  cfg.showJitter,
+
+			percentiles: cfg.percentiles,
+			template:    cfg.template,
+		}
+		if len(cfg.hosts) > 1 {
+			hf.label = cfg.host + ": "
 		}
+		if cfg.resolve && !cfg.numeric {
+			hf.resolver = newResolver()
+			// the destination usually responds, start resolving it right away
+			hf.resolver.format(ip)
+		}
+		out.format = hf
 	}
-
-	fmt.Printf(
-		"64 bytes from %s: icmp_seq=%d ttl=%d time=%dms\n",
-		p.dst.IP.String(),
-		p.seqnum,
-		ttl, // incoming `ttl` is different from outgoing `p.ttl`
-		rtt.Milliseconds(),
-	)
 }
 
-func (p *PingProc) handleTimeExceeded() {
-	fmt.Printf(
-		"From %s: icmp_seq=%d Time exceeded: Hop limit\n",
-		p.dst.IP.String(),
-		p.seqnum,
-	)
+// checkLinkMTU rejects echo requests which can't leave the outgoing
+// interface: bigger than its MTU, with fragmentation forbidden. Sweeps and
+// MTU discovery probe the limit on purpose.
+func checkLinkMTU(cfg config, p *pinger.Pinger) error {
+	if !cfg.noFrag || cfg.sweepMax > 0 || cfg.mtuDiscover || cfg.dryRun {
+		return nil
+	}
+	mtu := p.LinkMTU()
+	hdrLen := 20 + 8
+	if cfg.isIPv6 {
+		hdrLen = 40 + 8
+	}
+	if mtu > 0 && cfg.size+hdrLen > mtu {
+		return fmt.Errorf(
+			"Packet size error: %d data bytes don't fit into the MTU %d of the outgoing interface, at most %d can be sent without fragmentation",
+			cfg.size,
+			mtu,
+			mtu-hdrLen,
+		)
+	}
+	return nil
 }
 
-// handleMsg is a general received message handler.
-func (p *PingProc) handleMsg(msg *icmp.Message, ttl int) {
-	switch msg.Type {
-	case ipv4.ICMPTypeEchoReply:
-		fallthrough
-	case ipv6.ICMPTypeEchoReply:
-		p.handleEchoReply(msg, ttl)
-	case ipv4.ICMPTypeTimeExceeded:
-		fallthrough
-	case ipv6.ICMPTypeTimeExceeded:
-		p.handleTimeExceeded()
-	default:
-		fmt.Printf("Unexpected message type received.")
+// run pings the destination until `ctx` is done or the run completes and
+// returns the exit code.
+func (t *target) run(ctx context.Context) int {
+	cfg, p, out := &t.cfg, t.p, t.out
+	defer p.Close()
+	if out.progress != nil {
+		defer out.progress.finish()
 	}
-}
 
-func pingLoop(p *PingProc, cn *icmp.PacketConn) error {
-	ping := make(chan recvResult)
-	go p.recvEchoReply(cn, ping)
-	p.sendEcho(cn)
-	timer := time.NewTimer(p.rttLimit)
-
-	for {
-		select {
-		case <-timer.C:
-			fmt.Printf("unreachable: %s.\n", p.dst.IP.String())
-		case res := <-ping:
-			if res.err == nil {
-				p.handleMsg(res.msg, res.ttl)
-			} else {
-				fmt.Printf("Error during message receiving: %s.\n", res.err)
-			}
-			timer.Stop()
-			time.Sleep(p.interval)
+	if cfg.dryRun {
+		t.dryRun()
+		return 0
+	}
+
+	if cfg.traceroute {
+		if !cfg.json && !cfg.csv {
+			fmt.Fprintf(stdout,
+				"traceroute to %s (%s), %d hops max, %d byte packets\n",
+				cfg.host,
+				p.IPAddr().IP,
+				cfg.maxHops,
+				cfg.size+8,
+			)
+		}
+		hops, err := p.Traceroute(ctx)
+		if err != nil {
+			printError(cfg, err)
+			return exitError
 		}
+		out.printTraceStatistics(hops)
+		return 0
+	}
 
-		timer.Reset(p.rttLimit)
-		if err := p.sendEcho(cn); err != nil {
-			fmt.Printf("Send error: %s.\n", err)
-			break
+	if cfg.mtuDiscover {
+		mtu, err := p.DiscoverMTU(ctx)
+		if err != nil {
+			printError(cfg, err)
+			return exitError
 		}
+		out.printMTU(mtu)
+		return 0
 	}
 
-	timer.Stop()
-	return nil
+	if cfg.failAfter > 0 {
+		var stop func()
+		ctx, stop = watchdog(ctx, &out.lastReply, cfg.failAfter)
+		defer stop()
+	}
+	stats, err := p.Run(ctx)
+	if err != nil {
+		printError(cfg, err)
+		return exitError
+	}
+	t.alive = stats.Received > 0
+	t.stats = &stats
+	// the table follows once all destinations are done
+	if !cfg.once && !cfg.table {
+		out.printStatistics(stats)
+	}
+	if cfg.deadlineExitFail && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		// the run didn't complete in time, whatever the replies
+		return 1
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, errSilent) {
+		printError(cfg, cause)
+		return 1
+	}
+	return stats.ExitCode()
 }
 
 func main() {
-	var host string
-	var isIPv6 bool
-	var ttl int
+	var cfg config
 
-	parseArgs(&host, &isIPv6, &ttl)
-
-	if strings.Index(host, ":") != -1 {
-		isIPv6 = true
+	parseArgs(&cfg)
+	if cfg.loopback {
+		os.Exit(selfTest(&cfg))
 	}
 
-	printArgs(&host, &isIPv6, &ttl)
+	// destinations which can't be resolved are skipped, the others still run
+	code := 0
+	var targets []*target
+	// many destinations share a connection per IP version instead of an
+	// own one each, unless they need per-socket options or see foreign
+	// messages
+	var conns *sharedConns
+	if len(cfg.hosts) > 1 && cfg.source == "" && !cfg.broadcast && !cfg.verbose && !cfg.dryRun && cfg.ttlMax == 0 && !cfg.recordRoute {
+		conns = &sharedConns{cfg: &cfg, conns: make(map[bool]*pinger.SharedConn)}
+	}
+	inRange := make(map[string]bool)
+	for _, r := range cfg.ranges {
+		for _, addr := range r.addrs {
+			inRange[addr] = true
+		}
+	}
+	versions := pickVersions(&cfg)
+	for _, host := range cfg.hosts {
+		hostCfg := cfg
+		hostCfg.host = host
+		if isIPv6, ok := versions[host]; ok {
+			hostCfg.isIPv6 = isIPv6
+		}
+		if inRange[host] && hostCfg.count == 0 {
+			// discovery needs a single echo request per address
+			hostCfg.count = 1
+		}
+		t, err := newTarget(hostCfg, conns)
+		if err != nil {
+			printError(&hostCfg, err)
+			code = exitError
+			continue
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		os.Exit(exitError)
+	}
 
-	network := "ip4:icmp"
-	if isIPv6 {
-		network = "ip6:ipv6-icmp"
+	if cfg.metricsAddr != "" {
+		all := make([]*metrics, 0, len(targets))
+		for _, t := range targets {
+			all = append(all, t.out.metrics)
+		}
+		if err := serveMetrics(cfg.metricsAddr, all); err != nil {
+			logger.Error(err.Error(), "addr", cfg.metricsAddr)
+			os.Exit(exitError)
+		}
+		logger.Info("Serving metrics", "addr", cfg.metricsAddr)
+	}
+	if cfg.progress && !cfg.dryRun && !cfg.traceroute && !cfg.mtuDiscover {
+		enableProgress(targets)
+	}
+	for _, t := range targets {
+		t.setFormat()
+	}
+	// the header is shared by all destinations
+	targets[0].out.format.header()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// a soft deadline is up to the runs, see --deadline-then-keep
+	if cfg.deadline > 0 && !cfg.deadlineKeep {
+		ctx, cancel = context.WithTimeout(ctx, cfg.deadline)
+		defer cancel()
+	}
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	go func() {
+		<-sigs
+		cancel()
+	}()
+
+	codes := make([]int, len(targets))
+	sem := make(chan struct{}, cfg.concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *target) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			codes[i] = t.run(ctx)
+		}(i, t)
+	}
+	wg.Wait()
+	if conns != nil {
+		conns.close()
 	}
 
-	res, err := net.ResolveIPAddr(network, host)
-	if err != nil {
-		fmt.Printf("Address resolving error: %s.\n", err)
-		os.Exit(1)
+	if cfg.table {
+		fmt.Fprintln(stdout)
+		printTable(targets)
 	}
 
-	p := newPingProc(net.IPAddr{IP: res.IP, Zone: res.Zone}, isIPv6, ttl)
-	cn := p.getConnection(network, "")
+	if len(cfg.ranges) > 0 {
+		alive := make(map[string]bool)
+		for _, t := range targets {
+			alive[t.cfg.host] = t.alive
+		}
+		for _, r := range cfg.ranges {
+			var live []string
+			for _, addr := range r.addrs {
+				if alive[addr] {
+					live = append(live, addr)
+				}
+			}
+			targets[0].out.format.liveHosts(r.prefix, len(r.addrs), live)
+		}
+	}
 
-	if err := pingLoop(p, cn); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	// the worst result wins: errors over destinations without replies
+	for _, c := range codes {
+		if c > code {
+			code = c
+		}
 	}
+	os.Exit(code)
 }
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rttBuckets are the upper bounds of the RTT histogram buckets in seconds.
+var rttBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// labelEscaper escapes label values for the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metrics counts the probes of a pinger run for the Prometheus exporter.
+type metrics struct {
+	host string
+
+	mu sync.Mutex
+	probeCounts
+}
+
+// probeCounts are the values of the metrics of a run.
+type probeCounts struct {
+	sent     int
+	received int
+	lost     int
+	buckets  []int // cumulative counts of RTTs, one per `rttBuckets` entry
+	rttCount int
+	rttSum   time.Duration
+}
+
+func newMetrics(host string) *metrics {
+	return &metrics{
+		host:        host,
+		probeCounts: probeCounts{buckets: make([]int, len(rttBuckets))},
+	}
+}
+
+// serveMetrics starts exposing the metrics of all `targets` on `addr` at
+// /metrics. Only listening errors are returned, the server itself runs in the
+// background.
+func serveMetrics(addr string, targets []*metrics) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("Metrics server error: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, targets)
+	})
+	go http.Serve(ln, mux)
+
+	return nil
+}
+
+func (m *metrics) onSend() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent++
+}
+
+func (m *metrics) onReply(rtt time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.received++
+	for i, bound := range rttBuckets {
+		if rtt.Seconds() <= bound {
+			m.buckets[i]++
+		}
+	}
+	m.rttCount++
+	m.rttSum += rtt
+}
+
+func (m *metrics) onLoss() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lost++
+}
+
+// snapshot copies the current values, so they can be written out without
+// holding the lock the receive path of the run needs.
+func (m *metrics) snapshot() probeCounts {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := m.probeCounts
+	counts.buckets = append([]int(nil), m.buckets...)
+	return counts
+}
+
+// writeMetrics prints the metrics of all `targets` in the Prometheus text
+// format, grouped by metric. A slow scraper doesn't hold up the runs, the
+// values are copied first.
+func writeMetrics(w io.Writer, targets []*metrics) {
+	counts := make([]probeCounts, len(targets))
+	for i, m := range targets {
+		counts[i] = m.snapshot()
+	}
+
+	counters := []struct {
+		name, help string
+		value      func(c probeCounts) int
+	}{
+		{"pinger_sent_total", "Number of echo requests sent.", func(c probeCounts) int { return c.sent }},
+		{"pinger_received_total", "Number of echo replies received, without duplicates.", func(c probeCounts) int { return c.received }},
+		{"pinger_lost_total", "Number of echo requests without a reply in time or answered by an ICMP error.", func(c probeCounts) int { return c.lost }},
+	}
+	for _, c := range counters {
+		fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+		for i, m := range targets {
+			fmt.Fprintf(w, "%s{%s} %d\n", c.name, m.label(), c.value(counts[i]))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP pinger_rtt_seconds Round trip times of echo replies.\n")
+	fmt.Fprintf(w, "# TYPE pinger_rtt_seconds histogram\n")
+	for i, m := range targets {
+		label, c := m.label(), counts[i]
+		for j, bound := range rttBuckets {
+			fmt.Fprintf(w, "pinger_rtt_seconds_bucket{%s,le=\"%g\"} %d\n", label, bound, c.buckets[j])
+		}
+		fmt.Fprintf(w, "pinger_rtt_seconds_bucket{%s,le=\"+Inf\"} %d\n", label, c.rttCount)
+		fmt.Fprintf(w, "pinger_rtt_seconds_sum{%s} %g\n", label, c.rttSum.Seconds())
+		fmt.Fprintf(w, "pinger_rtt_seconds_count{%s} %d\n", label, c.rttCount)
+	}
+}
+
+// label returns the labels of the target's samples.
+func (m *metrics) label() string {
+	return fmt.Sprintf(`host="%s"`, labelEscaper.Replace(m.host))
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/temirrr/Pinger/pinger"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// unreachableReasons describe Destination Unreachable codes of ICMPv4 (RFC 792,
+// RFC 1812) and ICMPv6 (RFC 4443).
+var (
+	unreachableReasonsV4 = map[int]string{
+		0:  "Destination Net Unreachable",
+		1:  "Destination Host Unreachable",
+		2:  "Destination Protocol Unreachable",
+		3:  "Destination Port Unreachable",
+		4:  "Frag needed and DF set",
+		5:  "Source Route Failed",
+		6:  "Destination Net Unknown",
+		7:  "Destination Host Unknown",
+		8:  "Source Host Isolated",
+		9:  "Destination Net Prohibited",
+		10: "Destination Host Prohibited",
+		11: "Destination Net Unreachable for Type of Service",
+		12: "Destination Host Unreachable for Type of Service",
+		13: "Packet filtered",
+		14: "Precedence Violation",
+		15: "Precedence Cutoff",
+	}
+	unreachableReasonsV6 = map[int]string{
+		0: "No route",
+		1: "Administratively prohibited",
+		2: "Beyond scope of source address",
+		3: "Address unreachable",
+		4: "Port unreachable",
+		5: "Source address failed ingress/egress policy",
+		6: "Reject route to destination",
+	}
+)
+
+// redirectReasonsV4 describe ICMPv4 Redirect codes (RFC 792). ICMPv6 has
+// only one kind of Redirect.
+var redirectReasonsV4 = map[int]string{
+	0: "Redirect Network",
+	1: "Redirect Host",
+	2: "Redirect Type of Service and Network",
+	3: "Redirect Type of Service and Host",
+}
+
+// paramProblemReasons describe Parameter Problem codes of ICMPv4 (RFC 792,
+// RFC 1108) and ICMPv6 (RFC 4443).
+var (
+	paramProblemReasonsV4 = map[int]string{
+		0: "Parameter Problem",
+		1: "Parameter Problem: Missing Required Option",
+		2: "Parameter Problem: Bad Length",
+	}
+	paramProblemReasonsV6 = map[int]string{
+		0: "Erroneous header field",
+		1: "Unrecognized Next Header",
+		2: "Unrecognized IPv6 option",
+	}
+)
+
+// formatter renders the events of a pinger run in one output format, e.g.
+// human readable lines or JSON objects.
+type formatter interface {
+	header() // called once at startup
+	reply(pkt pinger.Packet)
+	timestampReply(pkt pinger.Packet)
+	maskReply(pkt pinger.Packet)
+	timeExceeded(pkt pinger.Packet)
+	unreachable(pkt pinger.Packet, reason string)
+	packetTooBig(pkt pinger.Packet)
+	redirect(pkt pinger.Packet, reason string)
+	problem(pkt pinger.Packet, reason string) // Parameter Problem or Source Quench
+	unexpected(pkt pinger.Packet)
+	foreign(pkt pinger.Packet) // message not concerning our echo requests
+	timeout(seq int)
+	failure(err error)
+	hop(hop pinger.Hop)
+	traceStatistics(hops []pinger.HopStatistics) // end-of-traceroute summary
+	mtu(mtu int)
+	statistics(stats pinger.Statistics)
+	report(stats pinger.Statistics) // interim statistics
+	// statistics at the soft deadline, events after it are marked
+	deadline(stats pinger.Statistics)
+	// addresses of a range which replied, after all statistics
+	liveHosts(prefix string, total int, alive []string)
+	stateChange(up bool)
+}
+
+// output dispatches the events of a pinger run to the formatter, applying
+// the options common to all formats.
+type output struct {
+	isIPv6 bool
+	quiet  bool // print only the final statistics
+	flood  bool // print a dot per echo request and erase it on reply
+
+	floodWidth int // column count after which flood dots wrap, 0 for never
+	floodCol   int // column of the next flood dot
+
+	bellOnReply bool // ring the terminal bell on echo replies, even if quiet
+	bellOnLoss  bool // ring the terminal bell on lost echo requests
+
+	dump bool // print a hexdump of every received message after its line
+
+	lastReply atomic.Int64 // Unix nanoseconds of the last echo reply, for the watchdog
+
+	// prints only flips of the destination state instead of every probe,
+	// nil to disable
+	changes *reachability
+
+	format  formatter
+	metrics *metrics // counts probes for scraping, nil to disable
+
+	progress *progressTask // advances the progress bar with finished probes, nil to disable
+}
+
+// onSend marks a sent echo request with a dot in flood mode.
+func (o *output) onSend(seq int) {
+	if o.metrics != nil {
+		o.metrics.onSend()
+	}
+	if !o.flood || o.quiet {
+		return
+	}
+
+	// wrapping waits for the next dot, as the last one may still be erased
+	if o.floodWidth > 0 && o.floodCol == o.floodWidth {
+		fmt.Fprint(stdout, "\n")
+		o.floodCol = 0
+	}
+	fmt.Fprint(stdout, ".")
+	o.floodCol++
+}
+
+// onRecv is a general received message handler.
+func (o *output) onRecv(pkt pinger.Packet) {
+	if isAnswer(pkt) {
+		o.lastReply.Store(time.Now().UnixNano())
+	}
+	if o.metrics != nil && isAnswer(pkt) {
+		o.metrics.onReply(pkt.RTT)
+	}
+	// an ICMP error is the final answer, the request won't time out. Errors
+	// carry no RTT if the request timed out already.
+	if o.metrics != nil && isErrorAnswer(pkt) && pkt.RTT > 0 {
+		o.metrics.onLoss()
+	}
+	if o.progress != nil && !pkt.Dup && !pkt.BadSum {
+		o.progress.step()
+	}
+	if o.bellOnReply && isAnswer(pkt) {
+		fmt.Fprint(stdout, "\a")
+	}
+	if o.changes != nil {
+		o.observeChange(pkt)
+		return
+	}
+	if o.quiet {
+		return
+	}
+	if o.flood {
+		o.printFlood(pkt)
+		return
+	}
+
+	switch pkt.Type {
+	case ipv4.ICMPTypeEchoReply:
+		fallthrough
+	case ipv6.ICMPTypeEchoReply:
+		o.format.reply(pkt)
+	case ipv4.ICMPTypeTimestampReply:
+		o.format.timestampReply(pkt)
+	case pinger.ICMPTypeAddressMaskReply:
+		o.format.maskReply(pkt)
+	case ipv4.ICMPTypeTimeExceeded:
+		fallthrough
+	case ipv6.ICMPTypeTimeExceeded:
+		o.format.timeExceeded(pkt)
+	case ipv4.ICMPTypeDestinationUnreachable:
+		fallthrough
+	case ipv6.ICMPTypeDestinationUnreachable:
+		o.format.unreachable(pkt, o.unreachableReason(pkt))
+	case ipv6.ICMPTypePacketTooBig:
+		o.format.packetTooBig(pkt)
+	case ipv4.ICMPTypeRedirect:
+		fallthrough
+	case ipv6.ICMPTypeRedirect:
+		o.format.redirect(pkt, o.redirectReason(pkt))
+	case ipv4.ICMPTypeParameterProblem:
+		fallthrough
+	case ipv6.ICMPTypeParameterProblem:
+		o.format.problem(pkt, o.paramProblemReason(pkt))
+	case pinger.ICMPTypeSourceQuench:
+		o.format.problem(pkt, "Source Quench")
+	default:
+		o.format.unexpected(pkt)
+	}
+	o.printDump(pkt.Raw)
+}
+
+// printDump prints a hexdump of the received bytes `raw` if enabled.
+func (o *output) printDump(raw []byte) {
+	if o.dump {
+		fmt.Fprint(stdout, hex.Dump(raw))
+	}
+}
+
+// observeChange feeds a received message into the destination state. Echo
+// replies count as up, ICMP errors as down, other messages are ignored.
+func (o *output) observeChange(pkt pinger.Packet) {
+	switch pkt.Type {
+	case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply, ipv4.ICMPTypeTimestampReply,
+		pinger.ICMPTypeAddressMaskReply:
+		if pkt.Dup || pkt.BadSum {
+			return
+		}
+		o.changed(true)
+	case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded,
+		ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable,
+		ipv6.ICMPTypePacketTooBig, ipv4.ICMPTypeParameterProblem,
+		ipv6.ICMPTypeParameterProblem:
+		o.changed(false)
+	}
+}
+
+// changed records a probe result and prints the new state if it flipped.
+func (o *output) changed(up bool) {
+	if o.changes.observe(up) && !o.quiet {
+		o.format.stateChange(up)
+	}
+}
+
+// onForeign prints a message not concerning our echo requests, registered
+// in verbose mode only.
+func (o *output) onForeign(pkt pinger.Packet) {
+	if o.quiet {
+		return
+	}
+
+	o.format.foreign(pkt)
+	o.printDump(pkt.Raw)
+}
+
+// unreachableReason describes the code of a Destination Unreachable message.
+func (o *output) unreachableReason(pkt pinger.Packet) string {
+	reasons := unreachableReasonsV4
+	if o.isIPv6 {
+		reasons = unreachableReasonsV6
+	}
+	reason, ok := reasons[pkt.Code]
+	if !ok {
+		reason = fmt.Sprintf("Destination Unreachable, Bad Code: %d", pkt.Code)
+	}
+	if pkt.MTU > 0 {
+		reason += fmt.Sprintf(" (mtu = %d)", pkt.MTU)
+	}
+
+	return reason
+}
+
+// redirectReason describes the code of a Redirect message.
+func (o *output) redirectReason(pkt pinger.Packet) string {
+	if o.isIPv6 {
+		return "Redirect"
+	}
+	reason, ok := redirectReasonsV4[pkt.Code]
+	if !ok {
+		reason = fmt.Sprintf("Redirect, Bad Code: %d", pkt.Code)
+	}
+
+	return reason
+}
+
+// paramProblemReason describes the code of a Parameter Problem message along
+// with the offset of the offending byte.
+func (o *output) paramProblemReason(pkt pinger.Packet) string {
+	reasons := paramProblemReasonsV4
+	if o.isIPv6 {
+		reasons = paramProblemReasonsV6
+	}
+	reason, ok := reasons[pkt.Code]
+	if !ok {
+		reason = fmt.Sprintf("Parameter Problem, Bad Code: %d", pkt.Code)
+	}
+	// the pointer is meaningless for a missing option
+	if o.isIPv6 || pkt.Code != 1 {
+		reason += fmt.Sprintf(" (pointer = %d)", pkt.Pointer)
+	}
+
+	return reason
+}
+
+// typeName names the ICMP message type `t`, including the deprecated ones
+// `ipv4` doesn't know.
+func typeName(t icmp.Type) string {
+	switch t {
+	case pinger.ICMPTypeSourceQuench:
+		return "source quench"
+	case pinger.ICMPTypeAddressMaskRequest:
+		return "address mask request"
+	case pinger.ICMPTypeAddressMaskReply:
+		return "address mask reply"
+	}
+	return fmt.Sprint(t)
+}
+
+// isAnswer reports whether `pkt` is the reply a probe waits for, neither a
+// duplicate nor one failing its checksum.
+func isAnswer(pkt pinger.Packet) bool {
+	return isReply(pkt) && !pkt.Dup && !pkt.BadSum
+}
+
+// isReply reports whether `pkt` answers a probe: an echo reply, IPv4 or IPv6,
+// a Timestamp Reply or an Address Mask Reply.
+func isReply(pkt pinger.Packet) bool {
+	switch pkt.Type {
+	case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply, ipv4.ICMPTypeTimestampReply,
+		pinger.ICMPTypeAddressMaskReply:
+		return true
+	}
+	return false
+}
+
+// isErrorAnswer reports whether `pkt` is an ICMP error answering a probe
+// instead of a reply, e.g. Destination Unreachable. Redirects don't answer,
+// the probe is forwarded anyway.
+func isErrorAnswer(pkt pinger.Packet) bool {
+	switch pkt.Type {
+	case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded,
+		ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable,
+		ipv6.ICMPTypePacketTooBig, ipv4.ICMPTypeParameterProblem,
+		ipv6.ICMPTypeParameterProblem, pinger.ICMPTypeSourceQuench:
+		return true
+	}
+	return false
+}
+
+// printFlood erases the dot of an answered echo request, an error message
+// replaces it with `E`. Dots of lost echo requests remain.
+func (o *output) printFlood(pkt pinger.Packet) {
+	if !isReply(pkt) {
+		fmt.Fprint(stdout, "\bE")
+		return
+	}
+	if !pkt.Dup && !pkt.BadSum && o.floodCol > 0 {
+		fmt.Fprint(stdout, "\b \b")
+		o.floodCol--
+	}
+}
+
+// onTimeout reports an echo request which got no reply in time.
+func (o *output) onTimeout(seq int) {
+	if o.metrics != nil {
+		o.metrics.onLoss()
+	}
+	if o.progress != nil {
+		o.progress.step()
+	}
+	if o.bellOnLoss {
+		fmt.Fprint(stdout, "\a")
+	}
+	if o.changes != nil {
+		o.changed(false)
+		return
+	}
+	if o.quiet || o.flood {
+		return
+	}
+
+	o.format.timeout(seq)
+}
+
+// onError reports a failure to send or receive a message.
+func (o *output) onError(err error) {
+	o.format.failure(err)
+	// the bytes of malformed messages are the interesting part
+	var parseErr *pinger.ParseError
+	if errors.As(err, &parseErr) {
+		o.printDump(parseErr.Raw)
+	}
+}
+
+// onHop reports a traceroute hop.
+func (o *output) onHop(hop pinger.Hop) {
+	o.format.hop(hop)
+}
+
+// printMTU prints the result of path MTU discovery.
+func (o *output) printMTU(mtu int) {
+	o.format.mtu(mtu)
+}
+
+// onReport prints interim statistics.
+func (o *output) onReport(stats pinger.Statistics) {
+	o.format.report(stats)
+}
+
+// onDeadline prints the statistics at the soft deadline, see
+// --deadline-then-keep.
+func (o *output) onDeadline(stats pinger.Statistics) {
+	o.format.deadline(stats)
+}
+
+// printTraceStatistics prints the per-hop summary at the end of traceroute.
+func (o *output) printTraceStatistics(hops []pinger.Hop) {
+	stats := make([]pinger.HopStatistics, 0, len(hops))
+	for _, hop := range hops {
+		stats = append(stats, hop.Statistics())
+	}
+	o.format.traceStatistics(stats)
+}
+
+// printStatistics prints the end-of-run summary.
+func (o *output) printStatistics(stats pinger.Statistics) {
+	o.format.statistics(stats)
+}
+
+func durationToMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/temirrr/Pinger/pinger"
+)
+
+// csvHeader names the columns of `--csv` output.
+var csvHeader = []string{"timestamp", "host", "ip", "seq", "ttl", "rtt_ms", "result"}
+
+// csvFormatter prints one CSV row per probe, for importing into spreadsheets.
+// Statistics are left out, as they don't fit the columns.
+type csvFormatter struct {
+	host string
+	ip   net.IP
+	w    *csv.Writer
+
+	// adds the after_deadline column, see --deadline-then-keep
+	deadlineCol bool
+	late        bool // whether the soft deadline has passed
+}
+
+func newCSVFormatter(w io.Writer, host string, ip net.IP, deadlineCol bool) *csvFormatter {
+	return &csvFormatter{host: host, ip: ip, w: csv.NewWriter(w), deadlineCol: deadlineCol}
+}
+
+// row prints a single row, zero `rtt` stands for none.
+func (f *csvFormatter) row(ip, seq, ttl string, rtt time.Duration, result string) {
+	rttStr := ""
+	if rtt > 0 {
+		rttStr = strconv.FormatFloat(durationToMs(rtt), 'f', 3, 64)
+	}
+
+	record := []string{
+		time.Now().Format(time.RFC3339Nano),
+		f.host,
+		ip,
+		seq,
+		ttl,
+		rttStr,
+		result,
+	}
+	if f.deadlineCol {
+		record = append(record, strconv.FormatBool(f.late))
+	}
+	f.w.Write(record)
+	f.w.Flush()
+}
+
+func (f *csvFormatter) header() {
+	header := csvHeader
+	if f.deadlineCol {
+		header = append(header[:len(header):len(header)], "after_deadline")
+	}
+	f.w.Write(header)
+	f.w.Flush()
+}
+
+func (f *csvFormatter) reply(pkt pinger.Packet) {
+	result := "reply"
+	switch {
+	case pkt.Dup:
+		result = "duplicate"
+	case pkt.Corrupt:
+		result = "corrupted"
+	case pkt.OutOfOrder:
+		result = "out_of_order"
+	case pkt.Warmup:
+		result = "warmup"
+	}
+	ttl := ""
+	if pkt.TTL >= 0 {
+		ttl = strconv.Itoa(pkt.TTL)
+	}
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), ttl, pkt.RTT, result)
+}
+
+func (f *csvFormatter) timestampReply(pkt pinger.Packet) {
+	result := "timestamp_reply"
+	if pkt.Dup {
+		result = "duplicate"
+	}
+	ttl := ""
+	if pkt.TTL >= 0 {
+		ttl = strconv.Itoa(pkt.TTL)
+	}
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), ttl, pkt.RTT, result)
+}
+
+func (f *csvFormatter) maskReply(pkt pinger.Packet) {
+	result := "address_mask_reply"
+	if pkt.Dup {
+		result = "duplicate"
+	}
+	ttl := ""
+	if pkt.TTL >= 0 {
+		ttl = strconv.Itoa(pkt.TTL)
+	}
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), ttl, pkt.RTT, result)
+}
+
+func (f *csvFormatter) timeExceeded(pkt pinger.Packet) {
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), "", pkt.RTT, "time_exceeded")
+}
+
+func (f *csvFormatter) unreachable(pkt pinger.Packet, reason string) {
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), "", pkt.RTT, "destination_unreachable")
+}
+
+func (f *csvFormatter) packetTooBig(pkt pinger.Packet) {
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), "", pkt.RTT, "packet_too_big")
+}
+
+func (f *csvFormatter) redirect(pkt pinger.Packet, reason string) {
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), "", 0, "redirect")
+}
+
+func (f *csvFormatter) problem(pkt pinger.Packet, reason string) {
+	result := "parameter_problem"
+	if pkt.Type == pinger.ICMPTypeSourceQuench {
+		result = "source_quench"
+	}
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), "", pkt.RTT, result)
+}
+
+func (f *csvFormatter) unexpected(pkt pinger.Packet) {
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), "", 0, "unexpected")
+}
+
+func (f *csvFormatter) foreign(pkt pinger.Packet) {
+	f.row(pkt.IP.String(), strconv.Itoa(pkt.Seq), "", 0, "foreign")
+}
+
+func (f *csvFormatter) timeout(seq int) {
+	f.row(f.ip.String(), strconv.Itoa(seq), "", 0, "timeout")
+}
+
+func (f *csvFormatter) failure(err error) {
+	f.row(f.ip.String(), "", "", 0, fmt.Sprintf("error: %s", err))
+}
+
+// hop prints a row per traceroute probe, with the hop's TTL.
+func (f *csvFormatter) hop(hop pinger.Hop) {
+	for _, probe := range hop.Probes {
+		if probe.IP == nil {
+			f.row("", "", strconv.Itoa(hop.TTL), 0, "timeout")
+			continue
+		}
+		f.row(probe.IP.String(), "", strconv.Itoa(hop.TTL), probe.RTT, "hop")
+	}
+}
+
+func (f *csvFormatter) stateChange(up bool) {
+	result := "down"
+	if up {
+		result = "up"
+	}
+	f.row(f.ip.String(), "", "", 0, result)
+}
+
+func (f *csvFormatter) mtu(mtu int) {
+	f.row(f.ip.String(), "", "", 0, fmt.Sprintf("mtu=%d", mtu))
+}
+
+func (f *csvFormatter) traceStatistics(hops []pinger.HopStatistics) {}
+
+func (f *csvFormatter) statistics(stats pinger.Statistics) {}
+
+func (f *csvFormatter) liveHosts(prefix string, total int, alive []string) {}
+
+func (f *csvFormatter) report(stats pinger.Statistics) {}
+
+// deadline prints a `deadline` row at the soft deadline, later rows are
+// marked in the after_deadline column.
+func (f *csvFormatter) deadline(stats pinger.Statistics) {
+	f.row(f.ip.String(), "", "", 0, "deadline")
+	f.late = true
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/temirrr/Pinger/pinger"
+)
+
+// humanFormatter prints events as human readable lines in the iputils style.
+type humanFormatter struct {
+	host  string
+	ip    net.IP
+	stamp bool   // prefix lines with the Unix time
+	label string // prefix of lines when several destinations are pinged
+
+	color   bool          // color replies, losses and errors
+	slowRTT time.Duration // replies slower than this are colored as slow
+	hist    bool          // add an RTT histogram to the final statistics
+	jitter  bool          // print the jitter of every reply
+
+	percentiles bool               // add RTT percentiles to the final statistics
+	template    *template.Template // renders reply lines instead of the default, see --output-template
+
+	resolver *resolver // resolves host names of addresses, nil to disable
+
+	lastRoute []net.IP // recorded route of the previous reply, see routeLines
+
+	late bool // whether the soft deadline has passed, see deadline
+}
+
+// addr formats `ip` for the human readable output.
+func (f *humanFormatter) addr(ip net.IP) string {
+	if f.resolver == nil {
+		return ip.String()
+	}
+	return f.resolver.format(ip)
+}
+
+// printf prints a line of human readable output, prefixed with the Unix time
+// like `[1712345678.123456] ` if enabled, a mark past the soft deadline and
+// the label. The line is written at once, so lines of concurrent destinations
+// don't mix.
+func (f *humanFormatter) printf(format string, a ...interface{}) {
+	prefix := ""
+	if f.stamp {
+		now := time.Now()
+		prefix = fmt.Sprintf("[%d.%06d] ", now.Unix(), now.Nanosecond()/1000)
+	}
+	if f.late {
+		prefix += "[after deadline] "
+	}
+	fmt.Fprint(stdout, prefix+f.label+fmt.Sprintf(format, a...))
+}
+
+// colorf is printf with the line colored in `color` if coloring is enabled.
+func (f *humanFormatter) colorf(color, format string, a ...interface{}) {
+	line := fmt.Sprintf(format, a...)
+	if f.color {
+		line = paint(color, line)
+	}
+	f.printf("%s", line)
+}
+
+// ttlString formats an incoming TTL, `?` if the platform didn't report it.
+func ttlString(ttl int) string {
+	if ttl < 0 {
+		return "?"
+	}
+	return strconv.Itoa(ttl)
+}
+
+// probeTTLString marks the answer to an echo request of a TTL sweep with its
+// outgoing TTL.
+func probeTTLString(pkt pinger.Packet) string {
+	if pkt.ProbeTTL == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (sent ttl=%d)", pkt.ProbeTTL)
+}
+
+// rttString formats a round trip time, `?` if it is unknown.
+func rttString(rtt time.Duration) string {
+	if rtt == 0 {
+		return "?"
+	}
+	return fmt.Sprintf("%.3f ms", durationToMs(rtt))
+}
+
+func (f *humanFormatter) header() {}
+
+func (f *humanFormatter) reply(pkt pinger.Packet) {
+	suffix := ""
+	if f.jitter && !pkt.Dup {
+		suffix = fmt.Sprintf(" jitter=%.3f ms", durationToMs(pkt.Jitter))
+	}
+	if pkt.Dup {
+		suffix += " (DUP!)"
+	}
+	if pkt.OutOfOrder {
+		suffix += " (out of order)"
+	}
+	if pkt.Warmup {
+		suffix += " (warmup)"
+	}
+	suffix += probeTTLString(pkt)
+	sameRoute := pkt.Route != nil && equalRoutes(pkt.Route, f.lastRoute)
+	if sameRoute {
+		suffix += "\t(same route)"
+	}
+	color := colorGreen
+	if f.slowRTT > 0 && pkt.RTT > f.slowRTT {
+		color = colorYellow
+	}
+	if f.template != nil {
+		f.colorf(color, "%s", f.templateLine(pkt))
+	} else {
+		f.colorf(
+			color,
+			"%d bytes from %s: icmp_seq=%d ttl=%s time=%s%s\n",
+			pkt.Bytes,
+			f.addr(pkt.IP),
+			pkt.Seq,
+			ttlString(pkt.TTL), // incoming `ttl` is different from outgoing one
+			rttString(pkt.RTT),
+			suffix,
+		)
+	}
+	if pkt.Corrupt {
+		f.printf("Warning: icmp_seq=%d corrupted packet!\n", pkt.Seq)
+	}
+	if pkt.Route != nil && !sameRoute {
+		f.printf("%s", f.routeLines(pkt.Route))
+		f.lastRoute = pkt.Route
+	}
+}
+
+// routeLines formats a recorded route like `ping -R`, one address per line.
+// A full option is marked, as the route may go on beyond it.
+func (f *humanFormatter) routeLines(route []net.IP) string {
+	var b strings.Builder
+	b.WriteString("RR:")
+	for _, ip := range route {
+		fmt.Fprintf(&b, "\t%s\n", f.addr(ip))
+	}
+	if len(route) == 0 {
+		b.WriteString("\n")
+	}
+	if len(route) == pinger.MaxRecordedHops {
+		fmt.Fprintf(&b, "\t(Record Route full: only the first %d hops are recorded)\n", pinger.MaxRecordedHops)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// equalRoutes reports whether two recorded routes list the same addresses.
+func equalRoutes(a, b []net.IP) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *humanFormatter) timestampReply(pkt pinger.Packet) {
+	offset := "?"
+	if pkt.OffsetKnown {
+		offset = fmt.Sprintf("%+d ms", pkt.Offset.Milliseconds())
+	}
+	suffix := ""
+	if pkt.Dup {
+		suffix = " (DUP!)"
+	}
+	f.colorf(
+		colorGreen,
+		"Timestamp reply from %s: icmp_seq=%d ttl=%s time=%.3f ms offset=%s%s\n",
+		f.addr(pkt.IP),
+		pkt.Seq,
+		ttlString(pkt.TTL),
+		durationToMs(pkt.RTT),
+		offset,
+		suffix,
+	)
+}
+
+func (f *humanFormatter) maskReply(pkt pinger.Packet) {
+	suffix := ""
+	if pkt.Dup {
+		suffix = " (DUP!)"
+	}
+	f.colorf(
+		colorGreen,
+		"Address mask reply from %s: icmp_seq=%d ttl=%s time=%s mask=%s%s\n",
+		f.addr(pkt.IP),
+		pkt.Seq,
+		ttlString(pkt.TTL),
+		rttString(pkt.RTT),
+		maskString(pkt.Mask),
+		suffix,
+	)
+}
+
+// maskString formats a subnet mask in dotted decimal, followed by the prefix
+// length if the mask is contiguous, e.g. `255.255.255.0 (/24)`.
+func maskString(mask net.IPMask) string {
+	if len(mask) != net.IPv4len {
+		return "?"
+	}
+	s := net.IP(mask).String()
+	if ones, bits := mask.Size(); bits != 0 {
+		s += fmt.Sprintf(" (/%d)", ones)
+	}
+	return s
+}
+
+func (f *humanFormatter) timeExceeded(pkt pinger.Packet) {
+	f.colorf(
+		colorRed,
+		"From %s: icmp_seq=%d Time exceeded: Hop limit%s\n",
+		f.addr(pkt.IP),
+		pkt.Seq,
+		probeTTLString(pkt),
+	)
+}
+
+func (f *humanFormatter) unreachable(pkt pinger.Packet, reason string) {
+	f.colorf(
+		colorRed,
+		"From %s: icmp_seq=%d %s\n",
+		f.addr(pkt.IP),
+		pkt.Seq,
+		reason,
+	)
+}
+
+func (f *humanFormatter) packetTooBig(pkt pinger.Packet) {
+	f.colorf(
+		colorRed,
+		"From %s: icmp_seq=%d Packet too big: mtu=%d\n",
+		f.addr(pkt.IP),
+		pkt.Seq,
+		pkt.MTU,
+	)
+}
+
+func (f *humanFormatter) redirect(pkt pinger.Packet, reason string) {
+	f.printf(
+		"From %s: icmp_seq=%d %s(New nexthop: %s)\n",
+		f.addr(pkt.IP),
+		pkt.Seq,
+		reason,
+		f.addr(pkt.Gateway),
+	)
+}
+
+func (f *humanFormatter) problem(pkt pinger.Packet, reason string) {
+	f.colorf(
+		colorRed,
+		"From %s: icmp_seq=%d %s\n",
+		f.addr(pkt.IP),
+		pkt.Seq,
+		reason,
+	)
+}
+
+func (f *humanFormatter) unexpected(pkt pinger.Packet) {
+	f.printf("Unexpected message type received.")
+}
+
+func (f *humanFormatter) foreign(pkt pinger.Packet) {
+	id := "?"
+	if pkt.ID >= 0 {
+		id = strconv.Itoa(pkt.ID)
+	}
+	f.printf(
+		"From %s: %s id=%s icmp_seq=%d (not ours)\n",
+		f.addr(pkt.IP),
+		typeName(pkt.Type),
+		id,
+		pkt.Seq,
+	)
+}
+
+func (f *humanFormatter) timeout(seq int) {
+	f.colorf(colorRed, "unreachable: %s.\n", f.addr(f.ip))
+}
+
+// failure logs the error instead of printing it with the probe results.
+func (f *humanFormatter) failure(err error) {
+	logger.Error(err.Error(), "host", f.host)
+}
+
+// hop prints a traceroute hop like `traceroute` does: responders with the
+// round trip times of their probes, `*` for probes without an answer.
+func (f *humanFormatter) hop(hop pinger.Hop) {
+	line := fmt.Sprintf("%2d ", hop.TTL)
+	var last net.IP
+	for _, probe := range hop.Probes {
+		if probe.IP == nil {
+			line += " *"
+			continue
+		}
+		if !probe.IP.Equal(last) {
+			line += "  " + f.addr(probe.IP)
+			last = probe.IP
+		}
+		line += fmt.Sprintf("  %.3f ms", durationToMs(probe.RTT))
+	}
+	fmt.Fprintln(stdout, line)
+}
+
+// traceStatistics prints a table of the hops like the report mode of `mtr`,
+// `???` stands for hops without any answer.
+func (f *humanFormatter) traceStatistics(hops []pinger.HopStatistics) {
+	addrs := make([]string, len(hops))
+	width := len("Address")
+	for i, hop := range hops {
+		names := make([]string, 0, len(hop.Responders))
+		for _, ip := range hop.Responders {
+			names = append(names, f.addr(ip))
+		}
+		addrs[i] = strings.Join(names, " ")
+		if addrs[i] == "" {
+			addrs[i] = "???"
+		}
+		width = max(width, len(addrs[i]))
+	}
+
+	// printed at once, like the ping statistics
+	summary := fmt.Sprintf("\n--- %s traceroute statistics ---\n", f.host)
+	summary += fmt.Sprintf("%3s  %-*s  %6s  %3s  %7s  %7s  %7s\n", "Hop", width, "Address", "Loss%", "Snt", "Min", "Avg", "Max")
+	for i, hop := range hops {
+		line := fmt.Sprintf("%3d  %-*s  %5.1f%%  %3d", hop.TTL, width, addrs[i], hop.Loss, hop.Sent)
+		if hop.Received > 0 {
+			line += fmt.Sprintf("  %7.3f  %7.3f  %7.3f",
+				durationToMs(hop.MinRTT),
+				durationToMs(hop.AvgRTT),
+				durationToMs(hop.MaxRTT),
+			)
+		}
+		summary += line + "\n"
+	}
+	fmt.Fprint(stdout, summary)
+}
+
+// liveHosts lists the addresses of a range which replied, after the
+// statistics of all destinations.
+func (f *humanFormatter) liveHosts(prefix string, total int, alive []string) {
+	summary := fmt.Sprintf("\n--- %s: %d of %d addresses alive ---\n", prefix, len(alive), total)
+	for _, addr := range alive {
+		summary += addr + "\n"
+	}
+	fmt.Fprint(stdout, summary)
+}
+
+func (f *humanFormatter) stateChange(up bool) {
+	state := "DOWN"
+	if up {
+		state = "UP"
+	}
+	f.printf("%s is %s\n", f.host, state)
+}
+
+func (f *humanFormatter) mtu(mtu int) {
+	fmt.Fprintf(stdout, "Path MTU to %s (%s): %d bytes\n", f.host, f.ip, mtu)
+}
+
+// report prints interim statistics in a single line, like iputils does on
+// SIGQUIT.
+func (f *humanFormatter) report(stats pinger.Statistics) {
+	f.printf(
+		"%d/%d packets, %g%% loss, min/avg/max = %.3f/%.3f/%.3f ms\n",
+		stats.Received,
+		stats.Transmitted,
+		stats.PacketLoss,
+		durationToMs(stats.MinRTT),
+		durationToMs(stats.AvgRTT),
+		durationToMs(stats.MaxRTT),
+	)
+}
+
+// statistics prints the end-of-run summary in the iputils format.
+func (f *humanFormatter) statistics(stats pinger.Statistics) {
+	fmt.Fprint(stdout, f.summary(fmt.Sprintf("%s ping statistics", stats.Host), stats))
+}
+
+// deadline prints the summary so far and marks the lines which follow.
+func (f *humanFormatter) deadline(stats pinger.Statistics) {
+	fmt.Fprint(stdout, f.summary(fmt.Sprintf("%s ping statistics at the deadline, pinging on", stats.Host), stats))
+	f.late = true
+}
+
+// summary formats statistics in the iputils format under the heading
+// `title`.
+func (f *humanFormatter) summary(title string, stats pinger.Statistics) string {
+	extra := ""
+	if stats.Duplicates > 0 {
+		extra += fmt.Sprintf(", +%d duplicates", stats.Duplicates)
+	}
+	if stats.Corrupted > 0 {
+		extra += fmt.Sprintf(", +%d corrupted", stats.Corrupted)
+	}
+	if stats.OutOfOrder > 0 {
+		extra += fmt.Sprintf(", +%d out of order", stats.OutOfOrder)
+	}
+	if stats.Redirects > 0 {
+		extra += fmt.Sprintf(", +%d redirects", stats.Redirects)
+	}
+	if stats.Errors > 0 {
+		extra += fmt.Sprintf(", +%d errors", stats.Errors)
+	}
+	if stats.Problems > 0 {
+		extra += fmt.Sprintf(", +%d problems", stats.Problems)
+	}
+	if stats.Resent > 0 {
+		extra += fmt.Sprintf(", +%d resent", stats.Resent)
+	}
+	if stats.Retried > 0 {
+		extra += fmt.Sprintf(", +%d retried", stats.Retried)
+	}
+
+	// printed at once, so summaries of concurrent destinations don't mix
+	summary := fmt.Sprintf("\n--- %s ---\n", title)
+	summary += fmt.Sprintf(
+		"%d packets transmitted, %d received%s, %g%% packet loss\n",
+		stats.Transmitted,
+		stats.Received,
+		extra,
+		stats.PacketLoss,
+	)
+
+	if len(stats.Responders) > 0 {
+		responders := make([]string, 0, len(stats.Responders))
+		for _, ip := range stats.Responders {
+			responders = append(responders, f.addr(ip))
+		}
+		summary += fmt.Sprintf(
+			"%d responders: %s\n",
+			len(responders),
+			strings.Join(responders, ", "),
+		)
+	}
+	if len(stats.RTTs) > 0 {
+		summary += fmt.Sprintf(
+			"rtt min/avg/max/mdev = %.3f/%.3f/%.3f/%.3f ms\n",
+			durationToMs(stats.MinRTT),
+			durationToMs(stats.AvgRTT),
+			durationToMs(stats.MaxRTT),
+			durationToMs(stats.MdevRTT),
+		)
+	}
+	if len(stats.RTTs) > 1 {
+		summary += fmt.Sprintf("jitter = %.3f ms\n", durationToMs(stats.Jitter))
+	}
+	if f.percentiles && len(stats.RTTs) > 0 {
+		summary += fmt.Sprintf(
+			"rtt p50/p95/p99 = %.3f/%.3f/%.3f ms\n",
+			durationToMs(stats.Percentile(50)),
+			durationToMs(stats.Percentile(95)),
+			durationToMs(stats.Percentile(99)),
+		)
+	}
+	if f.hist {
+		summary += histogram(stats.RTTs)
+	}
+	for _, res := range stats.Sweep {
+		if !res.Replied {
+			summary += fmt.Sprintf("size %d: lost\n", res.Size)
+			continue
+		}
+		summary += fmt.Sprintf("size %d: %.3f ms\n", res.Size, durationToMs(res.RTT))
+	}
+	return summary
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/temirrr/Pinger/pinger"
+)
+
+// jsonEvent is a single line of `--json` output describing one probe.
+type jsonEvent struct {
+	Type       string  `json:"type"`
+	Message    string  `json:"message,omitempty"` // ICMP message type of foreign messages
+	Host       string  `json:"host"`
+	IP         string  `json:"ip"`
+	From       string  `json:"from,omitempty"`
+	ID         int     `json:"id,omitempty"`
+	Seq        int     `json:"seq"`
+	Bytes      int     `json:"bytes,omitempty"`
+	TTL        int     `json:"ttl,omitempty"`       // left out if unknown
+	ProbeTTL   int     `json:"probe_ttl,omitempty"` // outgoing TTL in a TTL sweep
+	RTTMs      float64 `json:"rtt_ms,omitempty"`
+	JitterMs   float64 `json:"jitter_ms,omitempty"`
+	Duplicate  bool    `json:"duplicate,omitempty"`
+	Corrupt    bool    `json:"corrupt,omitempty"`
+	OutOfOrder bool    `json:"out_of_order,omitempty"`
+	Warmup     bool    `json:"warmup,omitempty"`
+	OffsetMs   *int64  `json:"offset_ms,omitempty"` // clock offset, left out if unknown
+	MTU        int     `json:"mtu,omitempty"`
+	Gateway    string  `json:"gateway,omitempty"`
+	Mask       string  `json:"mask,omitempty"` // subnet mask of Address Mask Replies
+	Late       bool    `json:"after_deadline,omitempty"`
+	State      string  `json:"state,omitempty"`
+	Error      string  `json:"error,omitempty"`
+	Timestamp  string  `json:"timestamp"`
+}
+
+// jsonStatistics is the `--json` counterpart of the end-of-run summary.
+type jsonStatistics struct {
+	Type        string  `json:"type"`
+	Host        string  `json:"host"`
+	Transmitted int     `json:"transmitted"`
+	Received    int     `json:"received"`
+	Duplicates  int     `json:"duplicates"`
+	Corrupted   int     `json:"corrupted"`
+	OutOfOrder  int     `json:"out_of_order"`
+	Errors      int     `json:"errors"`
+	Redirects   int     `json:"redirects"`
+	Problems    int     `json:"problems"`
+	Retried     int     `json:"retried"`
+	Resent      int     `json:"resent"`
+	LossPercent float64 `json:"loss_percent"`
+	MinMs       float64 `json:"min_ms"`
+	AvgMs       float64 `json:"avg_ms"`
+	MaxMs       float64 `json:"max_ms"`
+	MdevMs      float64 `json:"mdev_ms"`
+	JitterMs    float64 `json:"jitter_ms"`
+
+	P50Ms *float64 `json:"p50_ms,omitempty"`
+	P95Ms *float64 `json:"p95_ms,omitempty"`
+	P99Ms *float64 `json:"p99_ms,omitempty"`
+
+	Sweep      []jsonSweepResult `json:"sweep,omitempty"`
+	Responders []string          `json:"responders,omitempty"`
+}
+
+// jsonSweepResult is the `--json` output of one data size in sweep mode.
+type jsonSweepResult struct {
+	Size    int     `json:"size"`
+	Replied bool    `json:"replied"`
+	RTTMs   float64 `json:"rtt_ms,omitempty"`
+}
+
+// jsonHop is the `--json` output of a single traceroute hop.
+type jsonHop struct {
+	Type    string         `json:"type"`
+	Host    string         `json:"host"`
+	TTL     int            `json:"ttl"`
+	Probes  []jsonHopProbe `json:"probes"`
+	Reached bool           `json:"reached"`
+}
+
+// jsonTraceStatistics is the `--json` counterpart of the end-of-traceroute
+// summary.
+type jsonTraceStatistics struct {
+	Type string             `json:"type"`
+	Host string             `json:"host"`
+	Hops []jsonHopStatistic `json:"hops"`
+}
+
+type jsonHopStatistic struct {
+	TTL         int      `json:"ttl"`
+	Responders  []string `json:"responders"`
+	Sent        int      `json:"sent"`
+	Received    int      `json:"received"`
+	LossPercent float64  `json:"loss_percent"`
+	MinMs       float64  `json:"min_ms"`
+	AvgMs       float64  `json:"avg_ms"`
+	MaxMs       float64  `json:"max_ms"`
+}
+
+// jsonRange is the `--json` output of the live addresses of a range.
+type jsonRange struct {
+	Type      string   `json:"type"`
+	Range     string   `json:"range"`
+	Addresses int      `json:"addresses"`
+	Alive     []string `json:"alive"`
+}
+
+type jsonHopProbe struct {
+	IP    string  `json:"ip,omitempty"`
+	RTTMs float64 `json:"rtt_ms,omitempty"`
+}
+
+// jsonFormatter prints one JSON object per line.
+type jsonFormatter struct {
+	host        string
+	ip          net.IP
+	percentiles bool // add RTT percentiles to the statistics
+	late        bool // whether the soft deadline has passed, see deadline
+}
+
+// print prints `v` as a single line of JSON. Events get the destination
+// and the current time filled in.
+func (f *jsonFormatter) print(v interface{}) {
+	if ev, ok := v.(jsonEvent); ok {
+		ev.Host = f.host
+		ev.IP = f.ip.String()
+		ev.Late = f.late
+		ev.Timestamp = time.Now().Format(time.RFC3339Nano)
+		v = ev
+	}
+
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintf(stdout, "JSON encoding error: %s.\n", err)
+		return
+	}
+	fmt.Fprintln(stdout, string(bytes))
+}
+
+func (f *jsonFormatter) header() {}
+
+func (f *jsonFormatter) reply(pkt pinger.Packet) {
+	ttl := pkt.TTL
+	if ttl < 0 {
+		ttl = 0
+	}
+	f.print(jsonEvent{
+		Type:       "reply",
+		From:       pkt.IP.String(),
+		Seq:        pkt.Seq,
+		Bytes:      pkt.Bytes,
+		TTL:        ttl,
+		ProbeTTL:   pkt.ProbeTTL,
+		RTTMs:      durationToMs(pkt.RTT),
+		JitterMs:   durationToMs(pkt.Jitter),
+		Duplicate:  pkt.Dup,
+		Corrupt:    pkt.Corrupt,
+		OutOfOrder: pkt.OutOfOrder,
+		Warmup:     pkt.Warmup,
+	})
+}
+
+func (f *jsonFormatter) timestampReply(pkt pinger.Packet) {
+	ev := jsonEvent{
+		Type:      "timestamp_reply",
+		From:      pkt.IP.String(),
+		Seq:       pkt.Seq,
+		RTTMs:     durationToMs(pkt.RTT),
+		Duplicate: pkt.Dup,
+	}
+	if pkt.TTL >= 0 {
+		ev.TTL = pkt.TTL
+	}
+	if pkt.OffsetKnown {
+		offset := pkt.Offset.Milliseconds()
+		ev.OffsetMs = &offset
+	}
+	f.print(ev)
+}
+
+func (f *jsonFormatter) maskReply(pkt pinger.Packet) {
+	ev := jsonEvent{
+		Type:      "address_mask_reply",
+		From:      pkt.IP.String(),
+		Seq:       pkt.Seq,
+		RTTMs:     durationToMs(pkt.RTT),
+		Duplicate: pkt.Dup,
+	}
+	if pkt.TTL >= 0 {
+		ev.TTL = pkt.TTL
+	}
+	if len(pkt.Mask) == net.IPv4len {
+		ev.Mask = net.IP(pkt.Mask).String()
+	}
+	f.print(ev)
+}
+
+func (f *jsonFormatter) timeExceeded(pkt pinger.Packet) {
+	f.print(jsonEvent{Type: "time_exceeded", From: pkt.IP.String(), Seq: pkt.Seq, ProbeTTL: pkt.ProbeTTL})
+}
+
+func (f *jsonFormatter) unreachable(pkt pinger.Packet, reason string) {
+	f.print(jsonEvent{
+		Type:  "destination_unreachable",
+		From:  pkt.IP.String(),
+		Seq:   pkt.Seq,
+		Error: reason,
+		MTU:   pkt.MTU,
+	})
+}
+
+func (f *jsonFormatter) packetTooBig(pkt pinger.Packet) {
+	f.print(jsonEvent{
+		Type: "packet_too_big",
+		From: pkt.IP.String(),
+		Seq:  pkt.Seq,
+		MTU:  pkt.MTU,
+	})
+}
+
+func (f *jsonFormatter) redirect(pkt pinger.Packet, reason string) {
+	f.print(jsonEvent{
+		Type:    "redirect",
+		From:    pkt.IP.String(),
+		Seq:     pkt.Seq,
+		Error:   reason,
+		Gateway: pkt.Gateway.String(),
+	})
+}
+
+func (f *jsonFormatter) problem(pkt pinger.Packet, reason string) {
+	typ := "parameter_problem"
+	if pkt.Type == pinger.ICMPTypeSourceQuench {
+		typ = "source_quench"
+	}
+	f.print(jsonEvent{
+		Type:  typ,
+		From:  pkt.IP.String(),
+		Seq:   pkt.Seq,
+		Error: reason,
+	})
+}
+
+func (f *jsonFormatter) unexpected(pkt pinger.Packet) {
+	f.print(jsonEvent{Type: "unexpected", From: pkt.IP.String(), Seq: pkt.Seq})
+}
+
+func (f *jsonFormatter) foreign(pkt pinger.Packet) {
+	f.print(jsonEvent{
+		Type:    "foreign",
+		From:    pkt.IP.String(),
+		ID:      pkt.ID,
+		Seq:     pkt.Seq,
+		Message: typeName(pkt.Type),
+	})
+}
+
+func (f *jsonFormatter) timeout(seq int) {
+	f.print(jsonEvent{Type: "timeout", Seq: seq})
+}
+
+func (f *jsonFormatter) failure(err error) {
+	f.print(jsonEvent{Type: "error", Error: err.Error()})
+}
+
+func (f *jsonFormatter) hop(hop pinger.Hop) {
+	probes := make([]jsonHopProbe, 0, len(hop.Probes))
+	for _, probe := range hop.Probes {
+		jp := jsonHopProbe{RTTMs: durationToMs(probe.RTT)}
+		if probe.IP != nil {
+			jp.IP = probe.IP.String()
+		}
+		probes = append(probes, jp)
+	}
+	f.print(jsonHop{
+		Type:    "hop",
+		Host:    f.host,
+		TTL:     hop.TTL,
+		Probes:  probes,
+		Reached: hop.Reached,
+	})
+}
+
+func (f *jsonFormatter) traceStatistics(hops []pinger.HopStatistics) {
+	stats := make([]jsonHopStatistic, 0, len(hops))
+	for _, hop := range hops {
+		responders := make([]string, 0, len(hop.Responders))
+		for _, ip := range hop.Responders {
+			responders = append(responders, ip.String())
+		}
+		stats = append(stats, jsonHopStatistic{
+			TTL:         hop.TTL,
+			Responders:  responders,
+			Sent:        hop.Sent,
+			Received:    hop.Received,
+			LossPercent: hop.Loss,
+			MinMs:       durationToMs(hop.MinRTT),
+			AvgMs:       durationToMs(hop.AvgRTT),
+			MaxMs:       durationToMs(hop.MaxRTT),
+		})
+	}
+	f.print(jsonTraceStatistics{Type: "trace_statistics", Host: f.host, Hops: stats})
+}
+
+func (f *jsonFormatter) liveHosts(prefix string, total int, alive []string) {
+	if alive == nil {
+		alive = []string{}
+	}
+	f.print(jsonRange{Type: "range", Range: prefix, Addresses: total, Alive: alive})
+}
+
+func (f *jsonFormatter) stateChange(up bool) {
+	state := "down"
+	if up {
+		state = "up"
+	}
+	f.print(jsonEvent{Type: "state", State: state})
+}
+
+func (f *jsonFormatter) mtu(mtu int) {
+	f.print(jsonEvent{Type: "mtu", MTU: mtu})
+}
+
+func (f *jsonFormatter) statistics(stats pinger.Statistics) {
+	f.print(f.jsonStatistics("statistics", stats))
+}
+
+func (f *jsonFormatter) report(stats pinger.Statistics) {
+	f.print(f.jsonStatistics("report", stats))
+}
+
+// deadline prints the statistics so far and marks the events which follow.
+func (f *jsonFormatter) deadline(stats pinger.Statistics) {
+	f.print(f.jsonStatistics("deadline", stats))
+	f.late = true
+}
+
+// jsonStatistics converts statistics to their `--json` form.
+func (f *jsonFormatter) jsonStatistics(typ string, stats pinger.Statistics) jsonStatistics {
+	var sweep []jsonSweepResult
+	for _, res := range stats.Sweep {
+		sweep = append(sweep, jsonSweepResult{
+			Size:    res.Size,
+			Replied: res.Replied,
+			RTTMs:   durationToMs(res.RTT),
+		})
+	}
+
+	var responders []string
+	for _, ip := range stats.Responders {
+		responders = append(responders, ip.String())
+	}
+
+	js := jsonStatistics{
+		Type:        typ,
+		Host:        stats.Host,
+		Transmitted: stats.Transmitted,
+		Received:    stats.Received,
+		Duplicates:  stats.Duplicates,
+		Corrupted:   stats.Corrupted,
+		OutOfOrder:  stats.OutOfOrder,
+		Errors:      stats.Errors,
+		Redirects:   stats.Redirects,
+		Problems:    stats.Problems,
+		Retried:     stats.Retried,
+		Resent:      stats.Resent,
+		LossPercent: stats.PacketLoss,
+		MinMs:       durationToMs(stats.MinRTT),
+		AvgMs:       durationToMs(stats.AvgRTT),
+		MaxMs:       durationToMs(stats.MaxRTT),
+		MdevMs:      durationToMs(stats.MdevRTT),
+		JitterMs:    durationToMs(stats.Jitter),
+
+		Sweep:      sweep,
+		Responders: responders,
+	}
+	if f.percentiles && len(stats.RTTs) > 0 {
+		p50 := durationToMs(stats.Percentile(50))
+		p95 := durationToMs(stats.Percentile(95))
+		p99 := durationToMs(stats.Percentile(99))
+		js.P50Ms, js.P95Ms, js.P99Ms = &p50, &p95, &p99
+	}
+
+	return js
+}
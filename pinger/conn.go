@@ -0,0 +1,115 @@
+package pinger
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// packetConn is an ICMP endpoint like icmp.PacketConn, which additionally
+// keeps the underlying socket at hand for options x/net has no API for.
+type packetConn struct {
+	net.PacketConn
+	p4 *ipv4.PacketConn
+	p6 *ipv6.PacketConn
+
+	kernelStamps bool // whether the kernel attaches receive timestamps, see readMsg
+	ipOptions    bool // whether IPv4 options of received messages are wanted, see readMsg
+}
+
+// oobSize is the room for control messages of readMsg: TTL or hop limit and
+// a timestamp.
+const oobSize = 256
+
+// listenPacket opens an ICMP endpoint the same way `icmp.ListenPacket` does:
+// "udp4" and "udp6" networks give unprivileged datagram sockets bound to
+// `port`, 0 for any, "ip4:icmp" and "ip6:ipv6-icmp" give raw ones, which
+// have no ports.
+func listenPacket(network, address string, port int) (*packetConn, error) {
+	var c net.PacketConn
+	var err error
+	switch network {
+	case "udp4", "udp6":
+		c, err = listenDatagram(network, address, port)
+	default:
+		c, err = net.ListenPacket(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch network {
+	case "udp6", "ip6:ipv6-icmp":
+		return &packetConn{PacketConn: c, p6: ipv6.NewPacketConn(c)}, nil
+	}
+	return &packetConn{PacketConn: c, p4: ipv4.NewPacketConn(c)}, nil
+}
+
+// IPv4PacketConn returns the ipv4.PacketConn of an IPv4 endpoint.
+func (c *packetConn) IPv4PacketConn() *ipv4.PacketConn {
+	return c.p4
+}
+
+// IPv6PacketConn returns the ipv6.PacketConn of an IPv6 endpoint.
+func (c *packetConn) IPv6PacketConn() *ipv6.PacketConn {
+	return c.p6
+}
+
+// msgInfo describes a message read by readMsg.
+type msgInfo struct {
+	peer    net.Addr
+	ttl     int       // -1 if unknown
+	at      time.Time // kernel receive timestamp, zero if there is none
+	options []byte    // of the IPv4 header, raw sockets only
+}
+
+// readMsg reads a message like ReadFrom of ipv4 and ipv6, which only have
+// room for the control messages they enabled themselves and drop the IPv4
+// header, and additionally returns the kernel receive timestamp and the
+// IPv4 options. The IPv4 header raw sockets deliver is stripped.
+func (c *packetConn) readMsg(b []byte, isIPv6 bool) (n int, info msgInfo, err error) {
+	oob := make([]byte, oobSize)
+	var oobn int
+	switch pc := c.PacketConn.(type) {
+	case *net.UDPConn:
+		var addr *net.UDPAddr
+		n, oobn, _, addr, err = pc.ReadMsgUDP(b, oob)
+		info.peer = addr
+	case *net.IPConn:
+		var addr *net.IPAddr
+		n, oobn, _, addr, err = pc.ReadMsgIP(b, oob)
+		info.peer = addr
+		if err == nil && !isIPv6 {
+			hdrLen := int(b[0]&0x0f) << 2
+			if n == 0 || hdrLen < ipv4HeaderLen || hdrLen > n {
+				return 0, msgInfo{}, errors.New("truncated IPv4 header")
+			}
+			info.options = append([]byte(nil), b[ipv4HeaderLen:hdrLen]...)
+			n = copy(b, b[hdrLen:n])
+		}
+	default:
+		return 0, msgInfo{}, errors.New("control messages are not supported by the connection")
+	}
+	if err != nil {
+		return 0, msgInfo{}, err
+	}
+
+	info.ttl = -1
+	if !isIPv6 {
+		var cm ipv4.ControlMessage
+		if cm.Parse(oob[:oobn]) == nil && cm.TTL > 0 {
+			info.ttl = cm.TTL
+		}
+	} else {
+		var cm ipv6.ControlMessage
+		if cm.Parse(oob[:oobn]) == nil && cm.HopLimit > 0 {
+			info.ttl = cm.HopLimit
+		}
+	}
+	info.at, _ = kernelTimestamp(oob[:oobn])
+
+	return n, info, nil
+}
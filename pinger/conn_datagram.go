@@ -0,0 +1,88 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package pinger
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+)
+
+// sysIP_STRIPHDR makes darwin strip IPv4 headers from received datagrams.
+const sysIP_STRIPHDR = 0x17
+
+// listenDatagram opens a datagram-oriented ICMP socket, which doesn't need
+// privileges. Its local `port`, 0 for any, becomes the ICMP identifier of
+// echo requests.
+func listenDatagram(network, address string, port int) (net.PacketConn, error) {
+	family, proto := syscall.AF_INET, 1 // ICMP
+	if network == "udp6" {
+		family, proto = syscall.AF_INET6, 58 // ICMPv6
+	}
+
+	if port != 0 && datagramPortInUse(family == syscall.AF_INET6, port) {
+		return nil, os.NewSyscallError("bind", syscall.EADDRINUSE)
+	}
+
+	s, err := syscall.Socket(family, syscall.SOCK_DGRAM, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if runtime.GOOS == "darwin" && family == syscall.AF_INET {
+		if err := syscall.SetsockoptInt(s, syscall.IPPROTO_IP, sysIP_STRIPHDR, 1); err != nil {
+			syscall.Close(s)
+			return nil, os.NewSyscallError("setsockopt", err)
+		}
+	}
+
+	sa, err := datagramSockaddr(family, address, port)
+	if err != nil {
+		syscall.Close(s)
+		return nil, err
+	}
+	if err := syscall.Bind(s, sa); err != nil {
+		syscall.Close(s)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(s), "datagram-oriented icmp")
+	defer f.Close()
+
+	return net.FilePacketConn(f)
+}
+
+// datagramSockaddr converts a literal IP `address`, empty for any, and a
+// `port` into the socket address to bind to.
+func datagramSockaddr(family int, address string, port int) (syscall.Sockaddr, error) {
+	var addr net.IPAddr
+	if address != "" {
+		res, err := net.ResolveIPAddr("ip", address)
+		if err != nil {
+			return nil, err
+		}
+		addr = *res
+	}
+
+	if family == syscall.AF_INET {
+		sa := &syscall.SockaddrInet4{Port: port}
+		if ip := addr.IP.To4(); ip != nil {
+			copy(sa.Addr[:], ip)
+		}
+		return sa, nil
+	}
+
+	sa := &syscall.SockaddrInet6{Port: port}
+	if ip := addr.IP.To16(); ip != nil {
+		copy(sa.Addr[:], ip)
+	}
+	if addr.Zone != "" {
+		ifi, err := net.InterfaceByName(addr.Zone)
+		if err != nil {
+			return nil, err
+		}
+		sa.ZoneId = uint32(ifi.Index)
+	}
+	return sa, nil
+}
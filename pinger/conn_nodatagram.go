@@ -0,0 +1,15 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package pinger
+
+import (
+	"errors"
+	"net"
+)
+
+// listenDatagram fails, datagram-oriented ICMP sockets exist on darwin and
+// linux only.
+func listenDatagram(network, address string, port int) (net.PacketConn, error) {
+	return nil, errors.New("unprivileged ping is not supported on this platform")
+}
@@ -0,0 +1,65 @@
+package pinger
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// The deprecated ICMPv4 Address Mask types (RFC 950, RFC 6918), which `ipv4`
+// doesn't name.
+const (
+	ICMPTypeAddressMaskRequest = ipv4.ICMPType(17)
+	ICMPTypeAddressMaskReply   = ipv4.ICMPType(18)
+)
+
+// maskBodyLen is the length of an Address Mask message body: identifier,
+// sequence number and the subnet mask.
+const maskBodyLen = 8
+
+// maskRequest builds an ICMP Address Mask Request (type 17). x/net has no
+// body type for it, so it is raw.
+func (p *Pinger) maskRequest(seq int) *icmp.Message {
+	data := make([]byte, maskBodyLen)
+	binary.BigEndian.PutUint16(data[0:], uint16(p.id))
+	binary.BigEndian.PutUint16(data[2:], uint16(seq))
+
+	return &icmp.Message{
+		Type: ICMPTypeAddressMaskRequest,
+		Code: 0,
+		Body: &icmp.RawBody{Data: data},
+	}
+}
+
+// handleMaskReply fills in `pkt` from the body of an Address Mask Reply
+// (type 18) received at `at` and accounts replies to our requests like echo
+// replies.
+func (p *Pinger) handleMaskReply(data []byte, pkt *Packet, at time.Time) {
+	if len(data) < maskBodyLen {
+		return
+	}
+	pkt.ID = int(binary.BigEndian.Uint16(data[0:]))
+	pkt.Seq = int(binary.BigEndian.Uint16(data[2:]))
+	if pkt.ID != p.id {
+		return
+	}
+	pkt.Mask = net.IPMask(append([]byte(nil), data[4:8]...))
+
+	sentAt, ok := p.sentAt[pkt.Seq]
+	if !ok {
+		if p.replied[pkt.Seq] {
+			pkt.Dup = true
+			p.duplicates++
+		}
+		return
+	}
+	delete(p.sentAt, pkt.Seq)
+	delete(p.sentData, pkt.Seq)
+	p.replied[pkt.Seq] = true
+	pkt.RTT = at.Sub(sentAt)
+	p.addRTT(pkt)
+	p.updateSmoothRTT(pkt.RTT)
+}
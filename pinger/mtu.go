@@ -0,0 +1,166 @@
+package pinger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Largest ICMP data lengths fitting into an IP packet.
+const (
+	maxPayloadIPv4 = 65535 - ipv4HeaderLen - icmpHeaderLen
+	maxPayloadIPv6 = 65535 - icmpHeaderLen
+)
+
+// timestampLen is the size of the send time at the front of echo data.
+const timestampLen = 8
+
+// Header lengths without options or extension headers.
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	icmpHeaderLen = 8
+
+	maxIPv4HeaderLen = 60 // with options
+)
+
+// minRecvBufSize is the smallest buffer messages are read into, the Ethernet
+// MTU.
+const minRecvBufSize = 1500
+
+// defaultSocketBuffer is roughly the default socket receive buffer of Linux,
+// which is grown if a preload burst needs more.
+const defaultSocketBuffer = 208 << 10
+
+// LinkMTU returns the MTU of the interface echo requests leave through: the
+// one of the source address if bound, else the one the routing table picks
+// for the destination. It is 0 if that can't be told.
+func (p *Pinger) LinkMTU() int {
+	var local net.IP
+	if p.bindAddr != "" {
+		addr, err := net.ResolveIPAddr("ip", p.bindAddr)
+		if err != nil {
+			return 0
+		}
+		local = addr.IP
+	} else {
+		// connecting a UDP socket only asks the routing table, nothing is sent
+		c, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: p.dst.IP, Zone: p.dst.Zone, Port: 9})
+		if err != nil {
+			return 0
+		}
+		local = c.LocalAddr().(*net.UDPAddr).IP
+		c.Close()
+	}
+
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return 0
+	}
+	for _, ifi := range ifis {
+		// packets to an own address take the loopback interface
+		if local.Equal(p.dst.IP) {
+			if ifi.Flags&net.FlagLoopback != 0 {
+				return ifi.MTU
+			}
+			continue
+		}
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(local) {
+				return ifi.MTU
+			}
+		}
+	}
+	return 0
+}
+
+// DiscoverMTU binary-searches the largest echo request which reaches the
+// destination without fragmentation and returns the corresponding path MTU.
+// Requests are sent with the Don't Fragment bit, a request counts as too big
+// if it is answered by Fragmentation Needed (Packet Too Big), rejected
+// locally or not answered at all. Next-hop MTUs advertised by routers narrow
+// the search, but aren't required.
+func (p *Pinger) DiscoverMTU(ctx context.Context) (int, error) {
+	hdrLen, hi := ipv4HeaderLen+icmpHeaderLen, maxPayloadIPv4
+	if p.isIPv6 {
+		hdrLen, hi = ipv6HeaderLen+icmpHeaderLen, maxPayloadIPv6
+	}
+
+	p.noFrag = true
+	// the receive buffer is sized once, for the largest probe
+	p.size = hi
+	cn, err := p.getConnection(p.network(), p.bindAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer cn.Close()
+
+	ping, stop := p.startReceiving(ctx, cn)
+	defer stop()
+
+	// the smallest probe carrying a timestamp tells whether the host answers
+	lo := timestampLen
+	p.size = lo
+	fits, _, err := p.probeSize(ctx, cn, ping)
+	if err != nil {
+		return 0, err
+	}
+	if !fits {
+		return 0, errors.New("MTU discovery error: no reply to the smallest echo request")
+	}
+
+	for lo < hi {
+		size := (lo + hi + 1) / 2
+		p.size = size
+		fits, mtu, err := p.probeSize(ctx, cn, ping)
+		if err != nil {
+			return 0, err
+		}
+
+		if fits {
+			lo = size
+			continue
+		}
+		hi = size - 1
+		// a router told the MTU of the next hop, nothing bigger can pass
+		if mtu > 0 && mtu-hdrLen < hi {
+			hi = mtu - hdrLen
+			if hi < lo {
+				hi = lo
+			}
+		}
+	}
+
+	return lo + hdrLen, nil
+}
+
+// probeSize sends an echo request of `p.size` data bytes and reports whether
+// it got a reply, along with the next-hop MTU of a Fragmentation Needed
+// answer if there was one.
+func (p *Pinger) probeSize(ctx context.Context, cn *packetConn, ping <-chan recvResult) (fits bool, mtu int, err error) {
+	if err := p.sendEcho(ctx, cn); err != nil {
+		if ctx.Err() != nil {
+			return false, 0, errors.New("MTU discovery interrupted")
+		}
+		if errors.Is(err, syscall.EMSGSIZE) {
+			// the request exceeds the MTU of the outgoing interface
+			return false, 0, nil
+		}
+		return false, 0, err
+	}
+
+	pkt, answered, ok := p.awaitAnswer(ctx, ping)
+	if !ok {
+		return false, 0, errors.New("MTU discovery interrupted")
+	}
+	if !answered {
+		return false, 0, nil
+	}
+
+	return isEchoReply(pkt.Type), pkt.MTU, nil
+}
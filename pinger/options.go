@@ -0,0 +1,422 @@
+package pinger
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Option configures a Pinger.
+type Option func(*Pinger)
+
+// WithIPv6 makes the Pinger resolve the host to and ping an IPv6 address.
+func WithIPv6(isIPv6 bool) Option {
+	return func(p *Pinger) {
+		p.isIPv6 = isIPv6
+	}
+}
+
+// WithUnprivileged makes the Pinger send ICMP over datagram sockets, which
+// doesn't need root on Linux (see the net.ipv4.ping_group_range sysctl) and
+// macOS.
+func WithUnprivileged(udp bool) Option {
+	return func(p *Pinger) {
+		p.udp = udp
+	}
+}
+
+// WithLocalPort binds datagram sockets (WithUnprivileged) to local port
+// `port` instead of one the kernel picks, e.g. for firewall rules. As the
+// kernel makes the port the ICMP identifier, replies carry it too. Raw
+// sockets have no ports and ignore it.
+func WithLocalPort(port int) Option {
+	return func(p *Pinger) {
+		p.localPort = port
+	}
+}
+
+// WithID sets the ICMP identifier of echo requests instead of a random one,
+// e.g. to filter them in packet captures. Datagram sockets ignore it, the
+// kernel sets their identifier.
+func WithID(id int) Option {
+	return func(p *Pinger) {
+		p.id = id & 0xffff
+	}
+}
+
+// WithProtocol overrides the protocol number received messages are parsed
+// with, 1 (ICMP) or 58 (ICMPv6), e.g. to test stacks answering IPv4 echo
+// requests with ICMPv6 types. By default it follows the IP version.
+func WithProtocol(proto int) Option {
+	return func(p *Pinger) {
+		p.proto = proto
+	}
+}
+
+// WithStartSeq makes the first echo request carry sequence number `seq`
+// instead of a random one, so traces of different runs line up.
+func WithStartSeq(seq int) Option {
+	return func(p *Pinger) {
+		// the number is incremented before sending
+		p.seqnum = (seq - 1) & 0xffff
+	}
+}
+
+// WithNumeric makes New accept literal IP addresses only, so no DNS queries
+// are made for the destination.
+func WithNumeric(numeric bool) Option {
+	return func(p *Pinger) {
+		p.numeric = numeric
+	}
+}
+
+// WithResolver makes New look the destination up with `r` instead of
+// `net.DefaultResolver`, e.g. one querying a particular DNS server.
+func WithResolver(r Resolver) Option {
+	return func(p *Pinger) {
+		p.resolver = r
+	}
+}
+
+// WithSource makes the Pinger send from the interface or local IP address
+// `source`, e.g. "eth0" or "192.0.2.1". An interface is bound to by its first
+// address of the destination's IP version.
+func WithSource(source string) Option {
+	return func(p *Pinger) {
+		p.source = source
+	}
+}
+
+// WithTimestampProbe makes the Pinger send ICMP Timestamp requests (type 13)
+// instead of echo requests, IPv4 over raw sockets only. Timestamp Replies
+// carry the estimated clock offset of the remote host in `Packet.Offset`.
+func WithTimestampProbe(stampReq bool) Option {
+	return func(p *Pinger) {
+		p.stampReq = stampReq
+	}
+}
+
+// WithMaskProbe makes the Pinger send ICMP Address Mask requests (type 17)
+// instead of echo requests, IPv4 over raw sockets only. Address Mask Replies
+// carry the subnet mask of the remote host in `Packet.Mask`. The messages
+// are deprecated, mostly old devices still answer them.
+func WithMaskProbe(maskReq bool) Option {
+	return func(p *Pinger) {
+		p.maskReq = maskReq
+	}
+}
+
+// WithBroadcast allows pinging a broadcast address. Every host answering
+// is listed once in `Statistics.Responders`, replies after the first one
+// to an echo request count as duplicates.
+func WithBroadcast(broadcast bool) Option {
+	return func(p *Pinger) {
+		p.broadcast = broadcast
+	}
+}
+
+// WithDontFragment sets the Don't Fragment bit on IPv4 echo requests and
+// forbids fragmenting IPv6 ones, so oversized requests get Fragmentation
+// Needed (Packet Too Big) errors carrying the path MTU.
+func WithDontFragment(noFrag bool) Option {
+	return func(p *Pinger) {
+		p.noFrag = noFrag
+	}
+}
+
+// WithVerifyChecksum makes the Pinger recompute the ICMP checksum of IPv4
+// echo replies and count those where it doesn't match as corrupted rather
+// than received, as raw sockets deliver messages before the kernel checks
+// them.
+func WithVerifyChecksum(verify bool) Option {
+	return func(p *Pinger) {
+		p.verifySum = verify
+	}
+}
+
+// WithRecordRoute sends echo requests with the IPv4 Record Route option,
+// asking routers and the destination to fill in their addresses, which
+// replies report as Packet.Route. The option has room for MaxRecordedHops
+// addresses. It needs a raw IPv4 socket, and many routers ignore or drop
+// packets with options.
+func WithRecordRoute(enable bool) Option {
+	return func(p *Pinger) {
+		p.recRoute = enable
+	}
+}
+
+// WithKernelTimestamps takes the receive times of echo replies from the
+// kernel (SO_TIMESTAMPING on Linux), or the network card if it supports
+// hardware timestamps, instead of reading the clock after the read returns.
+// This keeps scheduling delays out of the RTTs. Kernel timestamps are wall
+// clock times, so RTTs follow steps of the system clock then. Where
+// unsupported, the clock is read as usual.
+func WithKernelTimestamps(enable bool) Option {
+	return func(p *Pinger) {
+		p.kernStamp = enable
+	}
+}
+
+// WithPattern fills echo data after the timestamp with repetitions of
+// `pattern`.
+func WithPattern(pattern []byte) Option {
+	return func(p *Pinger) {
+		p.pattern = pattern
+	}
+}
+
+// WithTTL sets the TTL (hop limit for IPv6) of outgoing echo requests.
+func WithTTL(ttl int) Option {
+	return func(p *Pinger) {
+		p.ttl = ttl
+	}
+}
+
+// WithTOS sets the Type of Service byte (Traffic Class for IPv6) of outgoing
+// echo requests, e.g. to test DSCP based QoS.
+func WithTOS(tos int) Option {
+	return func(p *Pinger) {
+		p.tos = tos
+	}
+}
+
+// WithCount stops the Pinger after `count` echo requests. 0 means no limit.
+func WithCount(count int) Option {
+	return func(p *Pinger) {
+		p.count = count
+	}
+}
+
+// WithInterval sets the time between echo requests.
+func WithInterval(interval time.Duration) Option {
+	return func(p *Pinger) {
+		p.interval = interval
+	}
+}
+
+// WithTimeout sets the time to wait for a reply before the echo request is
+// counted as lost.
+func WithTimeout(timeout time.Duration) Option {
+	return func(p *Pinger) {
+		p.rttLimit = timeout
+	}
+}
+
+// WithSize sets the number of ICMP data bytes in echo requests.
+func WithSize(size int) Option {
+	return func(p *Pinger) {
+		p.size = size
+	}
+}
+
+// WithSweep makes Run grow the data size of echo requests from `min` to
+// `max` by `step` per request, instead of using the fixed size, and stop
+// after the largest one. A `step` of 0 disables the sweep.
+func WithSweep(min, max, step int) Option {
+	return func(p *Pinger) {
+		p.sweepNext = min
+		p.sweepMax = max
+		p.sweepStep = step
+	}
+}
+
+// WithTTLSweep makes Run cycle the outgoing TTL (hop limit) of successive
+// echo requests from `min` to `max` instead of using the fixed one, so a
+// single run shows which TTLs reach the destination and which expire on
+// the way, see Packet.ProbeTTL. A `max` of 0 disables the sweep. The TTL is
+// set on the connection per request, which thus can't be shared.
+func WithTTLSweep(min, max int) Option {
+	return func(p *Pinger) {
+		p.ttlMin = min
+		p.ttlMax = max
+	}
+}
+
+// WithStopOnReply ends the run as soon as the first echo reply arrives, with
+// neither the count nor the interval waited for, e.g. for health checks.
+// Requests still in flight then are left unanswered.
+func WithStopOnReply(stop bool) Option {
+	return func(p *Pinger) {
+		p.stopOnReply = stop
+	}
+}
+
+// WithRetries makes Run resend an echo request without a reply in time up to
+// `retries` times under the same sequence number, so it counts as lost only
+// if none of them is answered. Default is 0.
+func WithRetries(retries int) Option {
+	return func(p *Pinger) {
+		p.retries = retries
+	}
+}
+
+// WithDrain sets how long Run waits at the end for replies to echo requests
+// still in flight, counting those arriving as received. 0 disables the wait,
+// by default it is the timeout, see WithTimeout.
+func WithDrain(drain time.Duration) Option {
+	return func(p *Pinger) {
+		p.drain = drain
+	}
+}
+
+// WithWarmup leaves the first `warmup` replies out of the RTT statistics,
+// since those often wait for ARP or neighbor discovery. They are still
+// counted as received and passed to OnRecv with `Packet.Warmup` set.
+func WithWarmup(warmup int) Option {
+	return func(p *Pinger) {
+		p.warmup = warmup
+	}
+}
+
+// WithPreload makes the Pinger send `preload` echo requests back-to-back at
+// startup, before pacing them by the interval. Default is 1.
+func WithPreload(preload int) Option {
+	return func(p *Pinger) {
+		p.preload = preload
+	}
+}
+
+// WithAdaptive makes the Pinger wait the moving average RTT between echo
+// requests instead of the interval, which becomes the upper bound. This keeps
+// throughput high on fast links and gentle on slow ones.
+func WithAdaptive(adaptive bool) Option {
+	return func(p *Pinger) {
+		p.adaptive = adaptive
+	}
+}
+
+// WithJitter varies every interval randomly by up to +/- `percent` percent,
+// so probes of many pingers don't synchronize. It is capped at 100.
+func WithJitter(percent float64) Option {
+	return func(p *Pinger) {
+		if percent > 100 {
+			percent = 100
+		}
+		p.jitter = percent
+	}
+}
+
+// WithRateLimiter makes every echo request wait for `limiter` first. Pingers
+// sharing a limiter are capped to its rate in total.
+func WithRateLimiter(limiter *rate.Limiter) Option {
+	return func(p *Pinger) {
+		p.limiter = limiter
+	}
+}
+
+// WithSharedConn makes Run send and receive through `conn` instead of an own
+// connection, which has to be of the same IP version and socket type. Socket
+// options like the TTL are those of `conn` then. Traceroute, DiscoverMTU and
+// multicast destinations still open their own.
+func WithSharedConn(conn *SharedConn) Option {
+	return func(p *Pinger) {
+		p.shared = conn
+	}
+}
+
+// WithReportInterval makes Run pass interim statistics to the OnReport
+// callback every `interval`, without stopping. 0 disables them.
+func WithReportInterval(interval time.Duration) Option {
+	return func(p *Pinger) {
+		p.reportInterval = interval
+	}
+}
+
+// WithSoftDeadline makes Run pass the statistics so far to the OnDeadline
+// callback once `deadline` has passed since its start, and go on pinging.
+// Unlike a context deadline it is a report boundary rather than a stop, e.g.
+// to tell the initial reachability of a flapping host from its long-term
+// behavior. 0 disables it.
+func WithSoftDeadline(deadline time.Duration) Option {
+	return func(p *Pinger) {
+		p.softDeadline = deadline
+	}
+}
+
+// WithMaxHops sets the largest TTL used by Traceroute.
+func WithMaxHops(maxHops int) Option {
+	return func(p *Pinger) {
+		p.maxHops = maxHops
+	}
+}
+
+// OnSend registers a callback called for every echo request sent, with its
+// sequence number.
+func OnSend(f func(seq int)) Option {
+	return func(p *Pinger) {
+		p.onSend = f
+	}
+}
+
+// OnRecv registers a callback called for every received message.
+func OnRecv(f func(Packet)) Option {
+	return func(p *Pinger) {
+		p.onRecv = f
+	}
+}
+
+// Packets makes the Pinger send every received message to `ch`, the channel
+// counterpart of OnRecv which it replaces. It isn't closed when the run ends.
+//
+// Receiving never waits for the consumer, which would stall the timing of
+// the run: a message arriving while `ch` is full is dropped and counted in
+// Statistics.Dropped. Give `ch` a buffer and drain it while Run is going on.
+func Packets(ch chan<- Packet) Option {
+	return func(p *Pinger) {
+		p.onRecv = func(pkt Packet) {
+			select {
+			case ch <- pkt:
+			default:
+				p.dropped++
+			}
+		}
+	}
+}
+
+// OnForeign registers a callback called for received messages which don't
+// concern echo requests of this Pinger, e.g. replies to other processes.
+// Without it foreign echo messages are dropped right after reading.
+func OnForeign(f func(Packet)) Option {
+	return func(p *Pinger) {
+		p.onForeign = f
+	}
+}
+
+// OnTimeout registers a callback called when the echo request with sequence
+// number `seq` got no reply in time.
+func OnTimeout(f func(seq int)) Option {
+	return func(p *Pinger) {
+		p.onTimeout = f
+	}
+}
+
+// OnError registers a callback called when sending or receiving fails.
+func OnError(f func(error)) Option {
+	return func(p *Pinger) {
+		p.onError = f
+	}
+}
+
+// OnHop registers a callback called by Traceroute once all echo requests
+// with the same TTL are answered or timed out.
+func OnHop(f func(Hop)) Option {
+	return func(p *Pinger) {
+		p.onHop = f
+	}
+}
+
+// OnDeadline registers a callback called with the statistics at the soft
+// deadline, see WithSoftDeadline.
+func OnDeadline(f func(Statistics)) Option {
+	return func(p *Pinger) {
+		p.onDeadline = f
+	}
+}
+
+// OnReport registers a callback called with interim statistics, see
+// WithReportInterval.
+func OnReport(f func(Statistics)) Option {
+	return func(p *Pinger) {
+		p.onReport = f
+	}
+}
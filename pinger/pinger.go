@@ -0,0 +1,1705 @@
+// Package pinger sends ICMP echo requests to a host and reports the replies.
+package pinger
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/time/rate"
+)
+
+// timeToBytes encodes `t` as the big-endian Unix time in nanoseconds, the
+// send time at the front of echo data. Times before 1970 work as well, in
+// two's complement.
+func timeToBytes(t time.Time) []byte {
+	bytes := make([]byte, timestampLen)
+	binary.BigEndian.PutUint64(bytes, uint64(t.UnixNano()))
+
+	return bytes
+}
+
+// bytesToTime decodes a send time encoded by timeToBytes, exact to the
+// nanosecond. It reports false if `bytes` is too short to hold one, e.g. the
+// echo data of requests smaller than 8 bytes.
+func bytesToTime(bytes []byte) (time.Time, bool) {
+	if len(bytes) < timestampLen {
+		return time.Time{}, false
+	}
+	nsecs := int64(binary.BigEndian.Uint64(bytes))
+
+	return time.Unix(nsecs/1000000000, nsecs%1000000000), true
+}
+
+// Packet is a message received in response to an echo request.
+type Packet struct {
+	Type  icmp.Type     // ICMP message type, e.g. `ipv4.ICMPTypeEchoReply`
+	Code  int           // ICMP message code
+	IP    net.IP        // source address of the message
+	ID    int           // ICMP identifier of the echo request, -1 if unknown
+	Seq   int           // sequence number of the echo request
+	TTL   int           // incoming TTL (hop limit), -1 if unknown
+	RTT   time.Duration // round trip time, set for echo replies only, 0 if unknown
+	Bytes int           // number of ICMP bytes, including the ICMP header
+	Dup   bool          // whether the echo reply is a duplicate
+	Err   error         // an *ICMPError if the message is an ICMP error answering an echo request
+	MTU   int           // next-hop MTU of Fragmentation Needed and Packet Too Big
+
+	Pointer  int // offset of the offending byte of a Parameter Problem, in the quoted datagram
+	ProbeTTL int // outgoing TTL of the echo request answered in a TTL sweep, 0 otherwise
+
+	Gateway net.IP // better first hop advertised by a Redirect
+	Foreign bool   // whether the message doesn't concern echo requests of this Pinger
+
+	Corrupt    bool // whether the echo data differs from the sent one, or BadSum is set
+	OutOfOrder bool // whether a later echo request got its reply first
+	BadSum     bool // whether the ICMP checksum failed, see WithVerifyChecksum; no answer then
+
+	Jitter time.Duration // RTT difference to the previous echo reply, 0 for the first
+	Warmup bool          // whether the reply is left out of the RTT statistics, see WithWarmup
+
+	Offset      time.Duration // clock offset of the remote host from a Timestamp Reply
+	OffsetKnown bool          // whether the remote host reported standard timestamps
+
+	Mask net.IPMask // subnet mask reported by an Address Mask Reply
+
+	Raw []byte // ICMP bytes as received, including the ICMP header
+
+	// Route lists the addresses recorded into the Record Route option of an
+	// echo reply, see WithRecordRoute. It is nil if the reply carries no such
+	// option and holds MaxRecordedHops addresses if the option is full.
+	Route []net.IP
+}
+
+// ParseError is the error of a received message which couldn't be parsed,
+// passed to OnError. It keeps the bytes for inspection.
+type ParseError struct {
+	From net.IP // source address of the message
+	Raw  []byte // ICMP bytes as received
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("Parsing message error: %s", e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ICMPError is the Err of a Packet which is an ICMP error message, e.g.
+// Destination Unreachable, answering an echo request of this Pinger.
+type ICMPError struct {
+	Type icmp.Type
+	Code int
+	From net.IP // source address of the message
+}
+
+func (e *ICMPError) Error() string {
+	return fmt.Sprintf("ICMP error: %v, code %d, from %s", e.Type, e.Code, e.From)
+}
+
+// ICMPTypeSourceQuench is the deprecated ICMPv4 Source Quench type (RFC 792,
+// RFC 6633), which `ipv4` doesn't name.
+const ICMPTypeSourceQuench = ipv4.ICMPType(4)
+
+// codeFragNeeded is the ICMPv4 Destination Unreachable code for
+// "Fragmentation Needed and DF set".
+const codeFragNeeded = 4
+
+// Resolver looks up the addresses of host names, as `net.Resolver` does,
+// which is the default. See WithResolver.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Pinger is a client's ping process.
+type Pinger struct {
+	id       int
+	ownID    int // identifier reserved in `usedIDs`, -1 once released
+	seqnum   int
+	host     string // destination as given by the user
+	numeric  bool   // accept literal IP addresses only, never query DNS
+	resolver Resolver
+	dst      net.IPAddr
+	source   string // interface name or local address to send from
+	bindAddr string // local address the connection is bound to
+	isIPv6   bool
+	udp      bool // unprivileged ICMP over datagram sockets
+	proto    int  // protocol number messages are parsed with, 0 for that of the IP version
+	noFrag   bool // forbid fragmentation of echo requests
+	recRoute bool // ask for the IPv4 Record Route option, see WithRecordRoute
+
+	verifySum bool // count echo replies with a wrong ICMP checksum as corrupted
+	kernStamp bool // take receive times from the kernel (SO_TIMESTAMPING)
+	localPort int  // local port of datagram sockets, 0 for any
+	ttl       int
+	tos       int           // Type of Service (IPv6 Traffic Class) byte
+	count     int           // number of echo requests to send, 0 means infinite
+	size      int           // number of ICMP data bytes
+	pattern   []byte        // fills echo data after the timestamp, nil for the default
+	rttLimit  time.Duration // time to wait for a reply
+	drain     time.Duration // time to wait for replies in flight at the end, negative for `rttLimit`
+	interval  time.Duration // time between echo signals
+	preload   int           // number of echo requests sent at once at startup
+	adaptive  bool          // pace echo requests by the RTT instead of the interval
+	maxHops   int           // largest TTL used in traceroute mode
+	jitter    float64       // percentage by which intervals vary randomly
+	retries   int           // times an unanswered echo request is resent before it is lost
+	warmup    int           // number of first replies left out of the RTT statistics
+	attempts  int           // times the last echo request was resent
+
+	reportInterval time.Duration // time between interim statistics, 0 for none
+	softDeadline   time.Duration // time after which statistics are reported once, 0 for none
+	limiter        *rate.Limiter // paces echo requests, possibly shared, nil for no limit
+	shared         *SharedConn   // connection shared with other Pingers, nil for an own one
+	stopOnReply    bool          // end the run with the first echo reply
+
+	broadcast bool           // whether pinging a broadcast address is allowed
+	stampReq  bool           // send ICMP Timestamp requests instead of echo requests
+	maskReq   bool           // send ICMP Address Mask requests instead of echo requests
+	mcastIfi  *net.Interface // outgoing interface of multicast echo requests, nil for the default
+
+	sweepNext int // data size of the next echo request in sweep mode
+	sweepMax  int // largest data size of the sweep
+	sweepStep int // growth of the data size per echo request, 0 for no sweep
+
+	onSend    func(seq int)
+	onRecv    func(Packet)
+	onForeign func(Packet)
+	onTimeout func(seq int)
+	onError   func(error)
+	onHop     func(Hop)
+	onReport  func(Statistics)
+
+	onDeadline func(Statistics)
+
+	sentAt     map[int]time.Time // send time of echo requests still awaiting reply
+	sentData   map[int][]byte    // echo data of requests still awaiting reply
+	replied    map[int]bool      // sequence numbers which already got a reply
+	highestSeq int               // highest sequence number replied to, -1 for none
+	sent       int               // number of echo requests transmitted
+	received   int               // number of matching echo replies
+	duplicates int               // number of duplicate echo replies
+	corrupted  int               // number of echo replies with altered data
+	outOfOrder int               // number of echo replies arriving out of order
+	errors     int               // number of ICMP error messages received
+	redirects  int               // number of ICMP Redirect messages received
+	problems   int               // number of Parameter Problem and Source Quench messages received
+	retried    int               // number of echo requests sent only after retrying
+	resent     int               // number of echo requests resent after a timeout
+	dropped    int               // number of received messages Packets had no room for
+	rtts       []time.Duration   // round trip times of matching echo replies
+	smoothRTT  time.Duration     // moving average of the round trip times
+	sweep      []SweepResult     // results per data size in sweep mode
+	sweepIdx   map[int]int       // `sweep` entries of requests awaiting reply
+	ttlMin     int               // smallest TTL of a TTL sweep, see WithTTLSweep
+	ttlMax     int               // largest TTL of a TTL sweep, 0 without one
+	ttlSeq     int               // sequence number of the first echo request of a TTL sweep
+	responders []net.IP          // distinct sources of echo replies to broadcasts and multicasts
+	seen       map[string]bool   // addresses of `responders`
+}
+
+var (
+	// rng is seeded once and shared by all pingers, guarded by rngMu
+	rng     = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rngMu   sync.Mutex
+	usedIDs = make(map[int]bool)
+)
+
+// randIntn is a concurrency safe version of `rand.Intn`.
+func randIntn(n int) int {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	return rng.Intn(n)
+}
+
+// randFloat64 is a concurrency safe version of `rand.Float64`.
+func randFloat64() float64 {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	return rng.Float64()
+}
+
+// newID returns a random ICMP identifier which is not used by any other
+// pinger, so their replies don't get mixed up. It fails once all of them are
+// taken.
+func newID() (int, error) {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	if len(usedIDs) >= 1<<16 {
+		return 0, errors.New("ICMP identifier error: all 65536 identifiers are in use")
+	}
+	for {
+		id := rng.Intn(1 << 16)
+		if !usedIDs[id] {
+			usedIDs[id] = true
+			return id, nil
+		}
+	}
+}
+
+// reserveID keeps newID from returning `id`, reporting false if it is in use
+// already.
+func reserveID(id int) bool {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	if usedIDs[id] {
+		return false
+	}
+	usedIDs[id] = true
+	return true
+}
+
+// releaseID makes `id` available to newID again.
+func releaseID(id int) {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+
+	delete(usedIDs, id)
+}
+
+// New resolves `host` and returns a Pinger for it. Without options it behaves
+// like the standard ping: IPv4, TTL 100, 56 data bytes, one echo request per
+// second until the context is cancelled.
+func New(host string, opts ...Option) (*Pinger, error) {
+	p := &Pinger{
+		id:       -1,
+		ownID:    -1,
+		seqnum:   randIntn(1 << 16),
+		host:     host,
+		resolver: net.DefaultResolver,
+		ttl:      100,
+		size:     56,
+		rttLimit: 2 * time.Second,
+		drain:    -1,
+		interval: time.Second,
+		preload:  1,
+		maxHops:  30,
+		sentAt:   make(map[int]time.Time),
+		sentData: make(map[int][]byte),
+		replied:  make(map[int]bool),
+		sweepIdx: make(map[int]int),
+		seen:     make(map[string]bool),
+
+		highestSeq: -1,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	// an identifier set by WithID is kept out of random draws instead, if
+	// no other Pinger has it yet
+	if p.id == -1 {
+		id, err := newID()
+		if err != nil {
+			return nil, err
+		}
+		p.id, p.ownID = id, id
+	} else if reserveID(p.id) {
+		p.ownID = p.id
+	}
+
+	var res *net.IPAddr
+	var err error
+	if p.numeric {
+		res, err = p.parseLiteral(host)
+	} else {
+		res, err = p.resolve(host)
+	}
+	if err != nil {
+		p.Close()
+		return nil, fmt.Errorf("Address resolving error: %s", err)
+	}
+	p.dst = net.IPAddr{IP: res.IP, Zone: res.Zone}
+
+	if p.source != "" {
+		if err := p.resolveSource(p.source); err != nil {
+			p.Close()
+			return nil, err
+		}
+	} else if isLinkLocal(p.dst.IP) && p.dst.Zone != "" {
+		// link-local traffic has to leave through the interface of the zone.
+		// Binding to its address is best effort, the zone alone routes too.
+		p.resolveSource(p.dst.Zone)
+	}
+	if p.dst.IP.IsMulticast() {
+		p.mcastIfi = p.multicastInterface()
+	}
+	if isLinkLocal(p.dst.IP) && p.dst.Zone == "" {
+		p.Close()
+		return nil, fmt.Errorf(
+			"Address resolving error: link-local address %s needs a zone, e.g. %s%%eth0, or -I",
+			p.dst.IP,
+			p.dst.IP,
+		)
+	}
+
+	return p, nil
+}
+
+// Close gives the ICMP identifier of the Pinger back, so Pingers created
+// later may draw it. Call it once the Pinger is done, it must not be used
+// any more.
+func (p *Pinger) Close() {
+	if p.ownID != -1 {
+		releaseID(p.ownID)
+		p.ownID = -1
+	}
+}
+
+// multicastInterface returns the interface multicast echo requests leave
+// through: the source interface if one was given, otherwise the one of the
+// destination's zone. Without either the system picks it, nil is returned.
+func (p *Pinger) multicastInterface() *net.Interface {
+	for _, name := range []string{p.source, p.dst.Zone} {
+		if name == "" || net.ParseIP(name) != nil {
+			continue
+		}
+		if ifi, err := interfaceByZone(name); err == nil {
+			return ifi
+		}
+	}
+	return nil
+}
+
+// isLinkLocal reports whether `ip` is an IPv6 link-local address, which is
+// only unique within the zone (interface) it belongs to.
+func isLinkLocal(ip net.IP) bool {
+	return ip.To4() == nil && (ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast())
+}
+
+// interfaceByZone looks up the interface of an IPv6 zone, given by name or
+// by index.
+func interfaceByZone(zone string) (*net.Interface, error) {
+	if index, err := strconv.Atoi(zone); err == nil {
+		return net.InterfaceByIndex(index)
+	}
+	return net.InterfaceByName(zone)
+}
+
+// resolve looks up the address of `host` of the Pinger's IP version, the
+// first one the resolver gives. If the host only has addresses of the other
+// version, the error says so instead of the resolver's bare "no suitable
+// address".
+func (p *Pinger) resolve(host string) (*net.IPAddr, error) {
+	want, other := "IPv4", "IPv6"
+	if p.isIPv6 {
+		want, other = "IPv6", "IPv4"
+	}
+
+	addrs, err := p.resolver.LookupIPAddr(context.Background(), host)
+	if err != nil {
+		return nil, err
+	}
+	for _, addr := range addrs {
+		// IPv4-mapped IPv6 addresses can't be pinged over ICMPv6
+		if (addr.IP.To4() == nil) == p.isIPv6 {
+			return &addr, nil
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("%s has no addresses", host)
+	}
+	return nil, fmt.Errorf("%s has no %s address, only %s ones", host, want, other)
+}
+
+// parseLiteral parses `host` as a literal IP address with an optional IPv6
+// zone, without ever querying DNS.
+func (p *Pinger) parseLiteral(host string) (*net.IPAddr, error) {
+	addr, zone := host, ""
+	if i := strings.LastIndex(host, "%"); i != -1 {
+		addr, zone = host[:i], host[i+1:]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("%s is not a literal IP address", host)
+	}
+	if (ip.To4() == nil) != p.isIPv6 {
+		if p.isIPv6 {
+			return nil, fmt.Errorf("%s is not an IPv6 address", host)
+		}
+		return nil, fmt.Errorf("%s is not an IPv4 address", host)
+	}
+
+	return &net.IPAddr{IP: ip, Zone: zone}, nil
+}
+
+// resolveSource turns `source` into the address to bind to. A literal IP is
+// used as is, an interface is replaced with its first address of the
+// destination's family, a link-local one for link-local destinations.
+func (p *Pinger) resolveSource(source string) error {
+	if net.ParseIP(source) != nil {
+		p.bindAddr = source
+		return nil
+	}
+
+	ifi, err := interfaceByZone(source)
+	if err != nil {
+		return fmt.Errorf("Source interface error: %s", err)
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return fmt.Errorf("Source interface error: %s", err)
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() == nil) != p.isIPv6 {
+			continue
+		}
+		if isLinkLocal(p.dst.IP) && !ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		src := net.IPAddr{IP: ipNet.IP}
+		if ipNet.IP.IsLinkLocalUnicast() {
+			src.Zone = ifi.Name
+		}
+		p.bindAddr = src.String()
+		// link-local destinations are only reachable through a given interface
+		if isLinkLocal(p.dst.IP) && p.dst.Zone == "" {
+			p.dst.Zone = ifi.Name
+		}
+		return nil
+	}
+
+	version := "IPv4"
+	if isLinkLocal(p.dst.IP) {
+		version = "link-local IPv6"
+	} else if p.isIPv6 {
+		version = "IPv6"
+	}
+	return fmt.Errorf("Source interface error: %s has no %s address", ifi.Name, version)
+}
+
+// Host returns the destination as it was given to New.
+func (p *Pinger) Host() string {
+	return p.host
+}
+
+// IPAddr returns the resolved destination address.
+func (p *Pinger) IPAddr() *net.IPAddr {
+	return &p.dst
+}
+
+func (p *Pinger) network() string {
+	switch {
+	case p.udp && p.isIPv6:
+		return "udp6"
+	case p.udp:
+		return "udp4"
+	case p.isIPv6:
+		return "ip6:ipv6-icmp"
+	}
+	return "ip4:icmp"
+}
+
+// dstAddr returns the destination in the form the connection writes to.
+func (p *Pinger) dstAddr() net.Addr {
+	if p.udp {
+		return &net.UDPAddr{IP: p.dst.IP, Zone: p.dst.Zone}
+	}
+	return &p.dst
+}
+
+// Run pings the destination until the count is exhausted, sending fails or
+// `ctx` is done, and returns the statistics of the run.
+func (p *Pinger) Run(ctx context.Context) (Statistics, error) {
+	var cn *packetConn
+	var err error
+	// multicast options, swept TTLs and Record Route are per socket, those
+	// destinations get their own
+	if p.shared != nil && !p.dst.IP.IsMulticast() && p.ttlMax == 0 && !p.recRoute {
+		if err := p.shared.attach(p); err != nil {
+			return Statistics{}, err
+		}
+		cn = p.shared.cn
+	} else {
+		cn, err = p.getConnection(p.network(), p.bindAddr)
+		if err != nil {
+			return Statistics{}, err
+		}
+		defer cn.Close()
+	}
+
+	if err := pingLoop(ctx, p, cn); err != nil {
+		return Statistics{}, err
+	}
+
+	return p.statistics(), nil
+}
+
+func (p *Pinger) getConnection(network, address string) (*packetConn, error) {
+	conn, err := listenPacket(network, address, p.localPort)
+	if err != nil {
+		return nil, listenError(err, p.udp)
+	}
+
+	if p.udp {
+		// the kernel replaces the ICMP identifier of datagram sockets with
+		// their local port, so replies carry the port as identifier
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+			p.id = addr.Port
+		}
+	}
+
+	// best effort: Windows has no control messages, incoming TTLs are
+	// unknown there
+	if !p.isIPv6 {
+		conn.IPv4PacketConn().SetControlMessage(ipv4.FlagTTL, true)
+	} else {
+		conn.IPv6PacketConn().SetControlMessage(ipv6.FlagHopLimit, true)
+	}
+	p.setTTL(conn, p.ttl)
+	// falls back to reading the clock after receiving if unsupported
+	if p.kernStamp {
+		conn.kernelStamps = enableKernelTimestamps(conn) == nil
+	}
+	// replies of a preload burst of large echo requests may not fit into
+	// the default socket buffer, growing it is best effort
+	if need := p.preload * p.recvBufSize(); need > defaultSocketBuffer {
+		if rb, ok := conn.PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+			rb.SetReadBuffer(need)
+		}
+	}
+	if p.tos != 0 {
+		if err := p.setTOS(conn, p.tos); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Setting TOS error: %s", err)
+		}
+	}
+	if p.dst.IP.IsMulticast() {
+		if err := p.setMulticast(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Setting multicast error: %s", err)
+		}
+	}
+	if p.broadcast {
+		if err := setBroadcast(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Setting broadcast error: %s", err)
+		}
+	}
+	if p.noFrag {
+		if err := setDontFragment(conn, p.isIPv6); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Setting Don't Fragment error: %s", err)
+		}
+	}
+	if p.recRoute {
+		if p.isIPv6 || p.udp {
+			conn.Close()
+			return nil, errors.New("Setting Record Route error: needs a raw IPv4 socket")
+		}
+		if err := setRecordRoute(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("Setting Record Route error: %s", err)
+		}
+		conn.ipOptions = true
+	}
+
+	return conn, nil
+}
+
+// errWSAEACCES is the Windows error of sockets which need more privileges
+// than the user has, e.g. raw ones without Administrator rights.
+const errWSAEACCES = syscall.Errno(10013)
+
+// listenError explains why opening the connection failed, pointing to the
+// privileges needed for the chosen socket type.
+func listenError(err error, udp bool) error {
+	denied := errors.Is(err, os.ErrPermission)
+	if runtime.GOOS == "windows" {
+		denied = denied || errors.Is(err, errWSAEACCES)
+	}
+	if errors.Is(err, syscall.EADDRINUSE) && udp {
+		return fmt.Errorf("Opening connection error: %w. The local port is taken by another socket", err)
+	}
+	if !denied {
+		return fmt.Errorf("Opening connection error: %w", err)
+	}
+
+	if udp {
+		return fmt.Errorf(
+			"Opening connection error: %w. Unprivileged ping is not permitted, "+
+				"on Linux allow your group in the net.ipv4.ping_group_range sysctl",
+			err,
+		)
+	}
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf(
+			"Opening connection error: %w. Raw sockets need Administrator rights on Windows, "+
+				"run pinger from an elevated command prompt",
+			err,
+		)
+	}
+	return fmt.Errorf(
+		"Opening connection error: %w. Raw sockets need root, run with sudo or use -u (unprivileged ping)",
+		err,
+	)
+}
+
+// setTTL sets the TTL (hop limit for IPv6) of subsequent echo requests.
+func (p *Pinger) setTTL(cn *packetConn, ttl int) error {
+	if !p.isIPv6 {
+		return cn.IPv4PacketConn().SetTTL(ttl)
+	}
+	return cn.IPv6PacketConn().SetHopLimit(ttl)
+}
+
+// setMulticast prepares sending echo requests to a multicast group: their TTL
+// (hop limit for IPv6), which defaults to 1 for multicast otherwise, and the
+// outgoing interface. Joining the group isn't needed, as replies are unicast.
+func (p *Pinger) setMulticast(cn *packetConn) error {
+	if !p.isIPv6 {
+		pc := cn.IPv4PacketConn()
+		if err := pc.SetMulticastTTL(p.ttl); err != nil {
+			return err
+		}
+		if p.mcastIfi != nil {
+			return pc.SetMulticastInterface(p.mcastIfi)
+		}
+		return nil
+	}
+
+	pc := cn.IPv6PacketConn()
+	if err := pc.SetMulticastHopLimit(p.ttl); err != nil {
+		return err
+	}
+	if p.mcastIfi != nil {
+		return pc.SetMulticastInterface(p.mcastIfi)
+	}
+	return nil
+}
+
+// setTOS sets the Type of Service byte (Traffic Class for IPv6), which
+// carries DSCP and ECN bits, of subsequent echo requests.
+func (p *Pinger) setTOS(cn *packetConn, tos int) error {
+	if !p.isIPv6 {
+		return cn.IPv4PacketConn().SetTOS(tos)
+	}
+	return cn.IPv6PacketConn().SetTrafficClass(tos)
+}
+
+func (p *Pinger) sendEcho(ctx context.Context, cn *packetConn) error {
+	// sequence number is 16 bits wide on the wire
+	p.seqnum = (p.seqnum + 1) & 0xffff
+	p.attempts = 0
+	if p.ttlMax > 0 {
+		if p.sent == 0 {
+			p.ttlSeq = p.seqnum
+		}
+		if err := p.setTTL(cn, p.probeTTL(p.seqnum)); err != nil {
+			return fmt.Errorf("Setting TTL error: %s", err)
+		}
+	}
+	return p.transmit(ctx, cn, false)
+}
+
+// probeTTL is the outgoing TTL of the echo request `seq` in a TTL sweep,
+// cycling from `ttlMin` to `ttlMax` and over again. Resent requests keep
+// theirs, as they keep the sequence number.
+func (p *Pinger) probeTTL(seq int) int {
+	n := (seq - p.ttlSeq) & 0xffff
+	return p.ttlMin + n%(p.ttlMax-p.ttlMin+1)
+}
+
+// NextRequest builds the next echo request the way Run sends it, advancing
+// the sequence number, and returns the marshalled ICMP message without
+// sending anything, e.g. for a dry run. ICMPv6 checksums are left 0, the
+// kernel computes them over a pseudo-header, and datagram sockets replace
+// the identifier.
+func (p *Pinger) NextRequest() []byte {
+	p.seqnum = (p.seqnum + 1) & 0xffff
+	msg, _ := p.echoRequest(time.Now())
+	bytes, _ := msg.Marshal(nil)
+	return bytes
+}
+
+// echoRequest builds the echo request with the current sequence number sent
+// at `now`, a Timestamp or Address Mask request in those modes, along with
+// the echo data its reply has to carry.
+func (p *Pinger) echoRequest(now time.Time) (*icmp.Message, []byte) {
+	if p.stampReq {
+		return p.timestampRequest(p.seqnum, now), nil
+	}
+	if p.maskReq {
+		return p.maskRequest(p.seqnum), nil
+	}
+
+	var msgType icmp.Type
+	if !p.isIPv6 {
+		msgType = ipv4.ICMPTypeEcho
+	} else {
+		msgType = ipv6.ICMPTypeEchoRequest
+	}
+	data := p.payload(now)
+	msg := &icmp.Message{
+		Type: msgType,
+		Code: 0,
+		Body: &icmp.Echo{
+			ID:   p.id,
+			Seq:  p.seqnum,
+			Data: data,
+		},
+	}
+	return msg, data
+}
+
+// resendEcho sends the last echo request again under its sequence number,
+// after it got no reply in time.
+func (p *Pinger) resendEcho(ctx context.Context, cn *packetConn) error {
+	p.attempts++
+	return p.transmit(ctx, cn, true)
+}
+
+// transmit sends an echo request with the current sequence number. A resent
+// one renews the send time, but counts as transmitted only once.
+func (p *Pinger) transmit(ctx context.Context, cn *packetConn, resend bool) error {
+	if p.limiter != nil {
+		if err := p.limiter.Wait(ctx); err != nil {
+			return fmt.Errorf("Send echo error: %w", err)
+		}
+	}
+
+	now := time.Now()
+	msg, data := p.echoRequest(now)
+	// checksum is calculated by `Marshal` method
+	bytes, _ := msg.Marshal(nil)
+
+	if err := p.write(ctx, cn, bytes); err != nil {
+		return fmt.Errorf("Send echo error: %w", err)
+	}
+	p.sentAt[p.seqnum] = now
+	p.sentData[p.seqnum] = data
+	if resend {
+		p.resent++
+		return nil
+	}
+	if p.sweepStep > 0 {
+		p.sweepIdx[p.seqnum] = len(p.sweep)
+		p.sweep = append(p.sweep, SweepResult{Size: p.size})
+	}
+	// the sequence number may be reused after wrapping around
+	delete(p.replied, p.seqnum)
+	p.sent++
+	if p.onSend != nil {
+		p.onSend(p.seqnum)
+	}
+
+	return nil
+}
+
+// Windows counterparts of the transient send errors.
+const (
+	errWSAEWOULDBLOCK = syscall.Errno(10035)
+	errWSAENOBUFS     = syscall.Errno(10055)
+)
+
+// Sending is retried `sendRetries` times on transient errors, waiting twice as
+// long as before on every attempt, starting at `sendBackoff`.
+const (
+	sendRetries = 3
+	sendBackoff = 10 * time.Millisecond
+)
+
+// write sends the marshalled message, retrying with a backoff while the
+// system runs out of buffers or the socket would block. Any other error is
+// returned right away.
+func (p *Pinger) write(ctx context.Context, cn *packetConn, b []byte) error {
+	backoff := sendBackoff
+	for attempt := 0; ; attempt++ {
+		_, err := cn.WriteTo(b, p.dstAddr())
+		if err == nil {
+			if attempt > 0 {
+				p.retried++
+			}
+			return nil
+		}
+		if attempt == sendRetries || !isTransient(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient tells whether a send error may go away by itself.
+func isTransient(err error) bool {
+	for _, errno := range []error{syscall.ENOBUFS, syscall.ENOMEM, syscall.EAGAIN, syscall.EINTR} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return errors.Is(err, errWSAENOBUFS) || errors.Is(err, errWSAEWOULDBLOCK)
+	}
+	return false
+}
+
+// payload builds echo data of `p.size` bytes: the send timestamp followed by
+// a repeating byte pattern.
+func (p *Pinger) payload(t time.Time) []byte {
+	data := make([]byte, p.size)
+	n := copy(data, timeToBytes(t))
+	for i := n; i < len(data); i++ {
+		data[i] = p.patternByte(i)
+	}
+
+	return data
+}
+
+// patternByte returns the echo data byte at offset `i` after the timestamp.
+func (p *Pinger) patternByte(i int) byte {
+	if len(p.pattern) == 0 {
+		return byte(i)
+	}
+	return p.pattern[(i-timestampLen)%len(p.pattern)]
+}
+
+type recvResult struct {
+	msg  *icmp.Message
+	raw  []byte   // ICMP bytes read, including the ICMP header
+	peer net.Addr // sender of the message, e.g. a router for Time Exceeded
+	ttl  int
+	at   time.Time // receive time, from the kernel with WithKernelTimestamps
+	err  error
+
+	malformed bool // `err` describes a message which couldn't be parsed, reading goes on
+
+	ipOpts []byte // options of the IPv4 header, read with WithRecordRoute only
+}
+
+// addrIP extracts the IP address from a peer address returned by `ReadFrom`.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.IPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+	return nil
+}
+
+// readPollInterval bounds a single blocking read, so the receiving goroutine
+// notices cancellation in time.
+const readPollInterval = 100 * time.Millisecond
+
+// isTimeout reports whether `err` is caused by an expired read deadline.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// recvEchoReply reads incoming messages of up to `bufSize` bytes and passes
+// them to `ch` until reading fails or `ctx` is done.
+func (p *Pinger) recvEchoReply(ctx context.Context, cn *packetConn, ch chan recvResult, bufSize int) {
+	deliver := func(res recvResult) bool {
+		select {
+		case ch <- res:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		res, timeout := readMessage(cn, p.isIPv6, p.proto, bufSize)
+		if timeout {
+			continue
+		}
+		if res.err != nil {
+			if !deliver(res) || !res.malformed {
+				return
+			}
+			continue
+		}
+		// raw sockets see all echo messages of the host, only ours are of
+		// interest unless somebody wants to log the others
+		if p.isForeignEcho(res.msg) && p.onForeign == nil {
+			continue
+		}
+
+		if !deliver(res) {
+			return
+		}
+	}
+}
+
+// readMessage reads and parses a single message of up to `bufSize` bytes
+// with protocol number `proto`, that of the IP version if 0. `timeout` tells
+// that none arrived within `readPollInterval`. A result with `err` set means
+// reading failed, unless it is `malformed`.
+func readMessage(cn *packetConn, isIPv6 bool, proto, bufSize int) (res recvResult, timeout bool) {
+	cn.SetReadDeadline(time.Now().Add(readPollInterval))
+
+	bytes := make([]byte, bufSize)
+
+	var n int
+	// some platforms don't deliver the control message
+	ttl := -1
+	var peer net.Addr
+	var at time.Time
+	var ipOpts []byte
+	var err error
+	if cn.kernelStamps || cn.ipOptions {
+		var info msgInfo
+		n, info, err = cn.readMsg(bytes, isIPv6)
+		peer, ttl, at, ipOpts = info.peer, info.ttl, info.at, info.options
+	} else if !isIPv6 {
+		var cm *ipv4.ControlMessage
+		n, cm, peer, err = cn.IPv4PacketConn().ReadFrom(bytes)
+		if cm != nil && cm.TTL > 0 {
+			ttl = cm.TTL
+		}
+	} else {
+		var cm *ipv6.ControlMessage
+		n, cm, peer, err = cn.IPv6PacketConn().ReadFrom(bytes)
+		if cm != nil && cm.HopLimit > 0 {
+			ttl = cm.HopLimit
+		}
+	}
+	if isTimeout(err) {
+		return recvResult{}, true
+	}
+	if err != nil {
+		return recvResult{ttl: -1, err: fmt.Errorf("Receive error: %s", err)}, false
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	if proto == 0 {
+		proto = ipv4.ICMPTypeEchoReply.Protocol()
+		if isIPv6 {
+			proto = ipv6.ICMPTypeEchoReply.Protocol()
+		}
+	}
+	// only the `n` bytes read are the message, e.g. a truncated one
+	msg, err := icmp.ParseMessage(proto, bytes[:n])
+	if err != nil {
+		recvErr := &ParseError{From: addrIP(peer), Raw: bytes[:n], Err: err}
+		return recvResult{ttl: -1, err: recvErr, malformed: true}, false
+	}
+
+	return recvResult{msg: msg, raw: bytes[:n], peer: peer, ttl: ttl, at: at, ipOpts: ipOpts}, false
+}
+
+// handleEchoReply does the bookkeeping for an echo reply received at `at`.
+// `badSum` tells that its checksum didn't verify, which makes it corrupted
+// like altered data does, but not received.
+func (p *Pinger) handleEchoReply(msg *icmp.Message, pkt *Packet, badSum bool, at time.Time) {
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if body.ID != p.id {
+			break
+		}
+		if p.broadcast || p.dst.IP.IsMulticast() {
+			p.addResponder(pkt.IP)
+		}
+		if sentAt, ok := p.sentAt[body.Seq]; ok {
+			if badSum {
+				// any byte may be the broken one, the reply doesn't answer
+				// the request, which keeps waiting for a good one
+				pkt.Corrupt, pkt.BadSum = true, true
+				p.corrupted++
+				return
+			}
+			if !bytes.Equal(body.Data, p.sentData[body.Seq]) {
+				pkt.Corrupt = true
+				p.corrupted++
+			}
+			if p.highestSeq != -1 && !seqAfter(body.Seq, p.highestSeq) {
+				pkt.OutOfOrder = true
+				p.outOfOrder++
+			} else {
+				p.highestSeq = body.Seq
+			}
+			delete(p.sentAt, body.Seq)
+			delete(p.sentData, body.Seq)
+			p.replied[body.Seq] = true
+			// the recorded send time carries a monotonic clock reading, so
+			// steps of the wall clock, e.g. by NTP, don't skew the RTT; the
+			// echoed one is verified along with the rest of the data
+			pkt.RTT = at.Sub(sentAt)
+			p.addRTT(pkt)
+			p.updateSmoothRTT(pkt.RTT)
+			if i, ok := p.sweepIdx[body.Seq]; ok {
+				p.sweep[i].Replied = true
+				p.sweep[i].RTT = pkt.RTT
+				delete(p.sweepIdx, body.Seq)
+			}
+		} else if p.replied[body.Seq] {
+			// duplicates don't affect the statistics apart from their
+			// counter, their send time is only known from the echo data
+			pkt.Dup = true
+			if sent, ok := bytesToTime(body.Data); ok {
+				pkt.RTT = at.Sub(sent)
+			}
+			p.duplicates++
+		}
+	}
+}
+
+// addRTT counts the reply `pkt` and records its round trip time, unless it
+// is one of the first `p.warmup` replies.
+func (p *Pinger) addRTT(pkt *Packet) {
+	p.received++
+	if p.received <= p.warmup {
+		pkt.Warmup = true
+		return
+	}
+	if len(p.rtts) > 0 {
+		pkt.Jitter = absDuration(pkt.RTT - p.rtts[len(p.rtts)-1])
+	}
+	p.rtts = append(p.rtts, pkt.RTT)
+}
+
+// addResponder records `ip` as a source of echo replies to broadcasts and
+// multicasts, once.
+func (p *Pinger) addResponder(ip net.IP) {
+	if ip == nil || p.seen[ip.String()] {
+		return
+	}
+	p.seen[ip.String()] = true
+	p.responders = append(p.responders, ip)
+}
+
+// updateSmoothRTT folds `rtt` into the moving average the way TCP does, each
+// sample weighing 1/8.
+func (p *Pinger) updateSmoothRTT(rtt time.Duration) {
+	if p.smoothRTT == 0 {
+		p.smoothRTT = rtt
+		return
+	}
+	p.smoothRTT += (rtt - p.smoothRTT) / 8
+}
+
+// minAdaptiveInterval is the shortest interval used in adaptive mode.
+const minAdaptiveInterval = 2 * time.Millisecond
+
+// nextInterval returns the time to wait before the next echo request, varied
+// randomly by the jitter.
+func (p *Pinger) nextInterval() time.Duration {
+	interval := p.baseInterval()
+	if p.jitter > 0 {
+		// uniform in [-jitter, +jitter] percent, at most 100 keeps it positive
+		factor := 1 + p.jitter/100*(2*randFloat64()-1)
+		interval = time.Duration(float64(interval) * factor)
+	}
+	return interval
+}
+
+// baseInterval returns the time to wait before the next echo request. In
+// adaptive mode it follows the moving average RTT, so about one echo request
+// is in flight, bounded by the configured interval.
+func (p *Pinger) baseInterval() time.Duration {
+	if !p.adaptive || p.smoothRTT == 0 {
+		return p.interval
+	}
+
+	interval := p.smoothRTT
+	if interval < minAdaptiveInterval {
+		interval = minAdaptiveInterval
+	}
+	if interval > p.interval {
+		interval = p.interval
+	}
+	return interval
+}
+
+// seqAfter reports whether sequence number `a` comes after `b`, taking the
+// 16 bit wraparound into account.
+func seqAfter(a, b int) bool {
+	return int16(a-b) > 0
+}
+
+// handleICMPError matches an ICMP error message received at `at` to the echo
+// request it was sent for, using the original datagram quoted in the message.
+// It reports whether the message concerns an echo request of this Pinger.
+func (p *Pinger) handleICMPError(data []byte, pkt *Packet, at time.Time) bool {
+	id, seq, ok := quotedEcho(data, p.isIPv6)
+	if !ok {
+		return false
+	}
+	pkt.ID = id
+	if id != p.id {
+		return false
+	}
+	pkt.Seq = seq
+	pkt.Err = &ICMPError{Type: pkt.Type, Code: pkt.Code, From: pkt.IP}
+	if sentAt, ok := p.sentAt[seq]; ok {
+		// the error is the final answer for this echo request
+		delete(p.sentAt, seq)
+		delete(p.sentData, seq)
+		pkt.RTT = at.Sub(sentAt)
+	}
+	return true
+}
+
+// validChecksum reports whether the Internet checksum (RFC 1071) of the
+// ICMPv4 message `b`, checksum field included, verifies. ICMPv6 checksums
+// cover a pseudo-header the kernel checks itself.
+func validChecksum(b []byte) bool {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return sum == 0xffff
+}
+
+// quotedEcho extracts ICMP identifier and sequence number of an echo request
+// quoted in an ICMP error message: the original IP header followed by at
+// least 8 bytes of the ICMP header.
+func quotedEcho(data []byte, isIPv6 bool) (id, seq int, ok bool) {
+	hdrLen := ipv6.HeaderLen
+	if !isIPv6 {
+		if len(data) == 0 || data[0]>>4 != 4 {
+			return 0, 0, false
+		}
+		// an IHL below 5 can't hold the header, whatever the sender claims
+		hdrLen = int(data[0]&0x0f) << 2
+		if hdrLen < ipv4.HeaderLen {
+			return 0, 0, false
+		}
+	}
+	if len(data) < hdrLen+8 {
+		return 0, 0, false
+	}
+
+	echo := data[hdrLen:]
+	id = int(echo[4])<<8 | int(echo[5])
+	seq = int(echo[6])<<8 | int(echo[7])
+
+	return id, seq, true
+}
+
+// optRedirectedHeader is the NDP option carrying the packet which caused an
+// ICMPv6 Redirect (RFC 4861).
+const optRedirectedHeader = 4
+
+// handleRedirect extracts the advertised gateway of a Redirect message and
+// matches it to the echo request it was sent for. Unlike errors, redirects
+// don't answer the echo request, which is still forwarded.
+func (p *Pinger) handleRedirect(data []byte, pkt *Packet) {
+	var quoted []byte
+	if !p.isIPv6 {
+		// gateway address followed by the original datagram (RFC 792)
+		if len(data) < net.IPv4len {
+			return
+		}
+		pkt.Gateway = net.IP(append([]byte(nil), data[:net.IPv4len]...))
+		quoted = data[net.IPv4len:]
+	} else {
+		// reserved, target and destination addresses, then options
+		if len(data) < 4+2*net.IPv6len {
+			return
+		}
+		pkt.Gateway = net.IP(append([]byte(nil), data[4:4+net.IPv6len]...))
+		opts := data[4+2*net.IPv6len:]
+		for len(opts) >= 8 {
+			optLen := int(opts[1]) * 8
+			if optLen == 0 || optLen > len(opts) {
+				break
+			}
+			if opts[0] == optRedirectedHeader {
+				quoted = opts[8:optLen]
+				break
+			}
+			opts = opts[optLen:]
+		}
+	}
+
+	id, seq, ok := quotedEcho(quoted, p.isIPv6)
+	if !ok {
+		return
+	}
+	pkt.ID = id
+	if id == p.id {
+		p.redirects++
+		pkt.Seq = seq
+	}
+}
+
+// parseMsg does the bookkeeping for a received message and describes it.
+func (p *Pinger) parseMsg(res recvResult) Packet {
+	msg := res.msg
+	pkt := Packet{
+		Type:  msg.Type,
+		Code:  msg.Code,
+		IP:    addrIP(res.peer),
+		ID:    -1,
+		Seq:   p.seqnum,
+		TTL:   res.ttl,
+		Bytes: len(res.raw),
+		Raw:   res.raw,
+	}
+
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		pkt.ID, pkt.Seq = body.ID, body.Seq
+		if isEchoReply(msg.Type) {
+			badSum := p.verifySum && !p.isIPv6 && !validChecksum(res.raw)
+			p.handleEchoReply(msg, &pkt, badSum, res.at)
+			pkt.Route = recordedRoute(res.ipOpts)
+		}
+	case *icmp.TimeExceeded:
+		if p.handleICMPError(body.Data, &pkt, res.at) {
+			p.errors++
+		}
+	case *icmp.DstUnreach:
+		if p.handleICMPError(body.Data, &pkt, res.at) {
+			p.errors++
+		}
+		// Fragmentation Needed carries the next-hop MTU in the otherwise
+		// unused header bytes (RFC 1191), which `icmp.DstUnreach` drops
+		if !p.isIPv6 && msg.Code == codeFragNeeded && len(res.raw) >= 8 {
+			pkt.MTU = int(res.raw[6])<<8 | int(res.raw[7])
+		}
+	case *icmp.PacketTooBig:
+		if p.handleICMPError(body.Data, &pkt, res.at) {
+			p.errors++
+		}
+		pkt.MTU = body.MTU
+	case *icmp.ParamProb:
+		if p.handleICMPError(body.Data, &pkt, res.at) {
+			p.problems++
+		}
+		pkt.Pointer = int(body.Pointer)
+	case *icmp.RawBody:
+		switch msg.Type {
+		case ICMPTypeSourceQuench:
+			// 4 unused bytes, then the original datagram
+			if len(body.Data) >= 4 && p.handleICMPError(body.Data[4:], &pkt, res.at) {
+				p.problems++
+			}
+			pkt.Foreign = pkt.ID != p.id
+		case ipv4.ICMPTypeRedirect, ipv6.ICMPTypeRedirect:
+			p.handleRedirect(body.Data, &pkt)
+			pkt.Foreign = pkt.ID != p.id
+		case ipv4.ICMPTypeTimestampReply:
+			p.handleTimestampReply(body.Data, &pkt, res.at)
+			pkt.Foreign = pkt.ID != p.id
+		case ICMPTypeAddressMaskReply:
+			p.handleMaskReply(body.Data, &pkt, res.at)
+			pkt.Foreign = pkt.ID != p.id
+		case ipv4.ICMPTypeTimestamp, ICMPTypeAddressMaskRequest:
+			// raw sockets see our own requests as well
+			pkt.Foreign = true
+		}
+	}
+
+	switch msg.Body.(type) {
+	case *icmp.Echo, *icmp.TimeExceeded, *icmp.DstUnreach, *icmp.PacketTooBig, *icmp.ParamProb:
+		// e.g. echo requests seen by raw sockets or replies to other processes
+		pkt.Foreign = !p.isOwnAnswer(msg)
+	}
+	if p.ttlMax > 0 && !pkt.Foreign && pkt.ID == p.id {
+		pkt.ProbeTTL = p.probeTTL(pkt.Seq)
+	}
+
+	return pkt
+}
+
+// handleMsg is a general received message handler.
+func (p *Pinger) handleMsg(res recvResult) Packet {
+	pkt := p.parseMsg(res)
+	if pkt.Foreign {
+		if p.onForeign != nil {
+			p.onForeign(pkt)
+		}
+		return pkt
+	}
+	if p.onRecv != nil {
+		p.onRecv(pkt)
+	}
+
+	return pkt
+}
+
+func (p *Pinger) handleTimeout() {
+	if p.onTimeout != nil {
+		p.onTimeout(p.seqnum)
+	}
+}
+
+func (p *Pinger) handleReport() {
+	if p.onReport != nil {
+		p.onReport(p.statistics())
+	}
+}
+
+func (p *Pinger) handleDeadline() {
+	if p.onDeadline != nil {
+		p.onDeadline(p.statistics())
+	}
+}
+
+func (p *Pinger) handleError(err error) {
+	if p.onError != nil {
+		p.onError(err)
+	}
+}
+
+// expireSent forgets echo requests which have waited longer than `rttLimit`.
+func (p *Pinger) expireSent() {
+	for seq, sentAt := range p.sentAt {
+		if time.Since(sentAt) >= p.rttLimit {
+			delete(p.sentAt, seq)
+			delete(p.sentData, seq)
+		}
+	}
+}
+
+// isEchoReply reports whether `t` is the echo reply type of either IP version.
+func isEchoReply(t icmp.Type) bool {
+	return t == ipv4.ICMPTypeEchoReply || t == ipv6.ICMPTypeEchoReply
+}
+
+// isForeignEcho reports whether `msg` is an echo message other than a reply
+// to this Pinger, e.g. an echo request or a reply to another process.
+func (p *Pinger) isForeignEcho(msg *icmp.Message) bool {
+	_, ok := msg.Body.(*icmp.Echo)
+	return ok && !p.isOwnAnswer(msg)
+}
+
+// isOwnAnswer reports whether `msg` answers an echo request of this Pinger.
+func (p *Pinger) isOwnAnswer(msg *icmp.Message) bool {
+	var quoted []byte
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		return isEchoReply(msg.Type) && body.ID == p.id
+	case *icmp.TimeExceeded:
+		quoted = body.Data
+	case *icmp.DstUnreach:
+		quoted = body.Data
+	case *icmp.PacketTooBig:
+		quoted = body.Data
+	case *icmp.ParamProb:
+		quoted = body.Data
+	default:
+		return false
+	}
+
+	id, _, ok := quotedEcho(quoted, p.isIPv6)
+	return ok && id == p.id
+}
+
+// awaitAnswer waits for the answer to the last echo request, skipping
+// messages sent for other ones. `answered` is false if none came within
+// `rttLimit`, `ok` is false once `ctx` is done or receiving failed.
+func (p *Pinger) awaitAnswer(ctx context.Context, ping <-chan recvResult) (pkt Packet, answered, ok bool) {
+	seq := p.seqnum
+	timer := time.NewTimer(p.rttLimit)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return Packet{}, false, false
+		case <-timer.C:
+			p.expireSent()
+			return Packet{}, false, true
+		case res := <-ping:
+			if res.malformed {
+				p.handleError(res.err)
+				continue
+			}
+			if res.err != nil {
+				p.handleError(res.err)
+				return Packet{}, false, false
+			}
+
+			pkt := p.parseMsg(res)
+			if pkt.Seq != seq || !p.isOwnAnswer(res.msg) || pkt.Dup || pkt.BadSum {
+				continue
+			}
+			return pkt, true, true
+		}
+	}
+}
+
+// answersLast reports whether `pkt`, handled already, ends the wait for the
+// last echo request: its reply, or an ICMP error about it. Late replies to
+// earlier requests, duplicates, replies failing their checksum and redirects
+// don't, the request still awaits its answer or the timeout.
+func (p *Pinger) answersLast(pkt Packet, msg *icmp.Message) bool {
+	if pkt.Foreign || pkt.Dup || pkt.BadSum || pkt.ID != p.id || pkt.Seq != p.seqnum {
+		return false
+	}
+	switch msg.Type {
+	case ipv4.ICMPTypeTimestampReply, ICMPTypeAddressMaskReply, ICMPTypeSourceQuench:
+		return true
+	}
+	return p.isOwnAnswer(msg)
+}
+
+// recvBufSize returns the size of the buffer a single message is read into:
+//
+//	max(data size + ICMP header (8) + largest IPv4 header (60), 1500)
+//
+// The data size is the largest one sent, raw IPv4 sockets deliver the IP
+// header in front of the message. The lower bound keeps ICMP errors whole,
+// which quote up to 1232 bytes of the request for IPv6 (RFC 4443).
+func (p *Pinger) recvBufSize() int {
+	size := p.size
+	if p.sweepStep > 0 {
+		size = p.sweepMax
+	}
+	bufSize := size + icmpHeaderLen + maxIPv4HeaderLen
+	if bufSize < minRecvBufSize {
+		bufSize = minRecvBufSize
+	}
+	return bufSize
+}
+
+// startReceiving runs recvEchoReply in a goroutine. The returned function
+// stops the goroutine and waits for it, so it never outlives the connection.
+func (p *Pinger) startReceiving(ctx context.Context, cn *packetConn) (<-chan recvResult, func()) {
+	if p.shared != nil && cn == p.shared.cn {
+		return p.shared.subscribe(ctx, p)
+	}
+
+	ping := make(chan recvResult)
+	ctx, cancel := context.WithCancel(ctx)
+	bufSize := p.recvBufSize()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.recvEchoReply(ctx, cn, ping, bufSize)
+	}()
+
+	return ping, func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// nextSize sets the data size of the next echo request in sweep mode and
+// reports whether the sweep goes on. Without a sweep it is always true.
+func (p *Pinger) nextSize() bool {
+	if p.sweepStep == 0 {
+		return true
+	}
+	if p.sweepNext > p.sweepMax {
+		return false
+	}
+	p.size = p.sweepNext
+	p.sweepNext += p.sweepStep
+
+	return true
+}
+
+// pingLoop sends echo requests until the count is exhausted, sending fails
+// or `ctx` is done, with WithStopOnReply also once a reply arrived.
+//
+// Every echo request gets `rttLimit` from its send time to be answered, the
+// timeout ends the wait for it. The next one follows the previous one by the
+// interval, no matter whether that got a reply or timed out. If the wait took
+// longer than the interval, it is sent right away.
+func pingLoop(ctx context.Context, p *Pinger, cn *packetConn) error {
+	// receiving outlives `ctx` for the drain
+	ping, stop := p.startReceiving(context.WithoutCancel(ctx), cn)
+	defer stop()
+
+	preload := p.preload
+	if p.count > 0 && preload > p.count {
+		preload = p.count
+	}
+	// the burst keeps `preload` echo requests in flight from then on
+	for i := 0; i < preload && p.nextSize(); i++ {
+		if err := p.sendEcho(ctx, cn); err != nil {
+			if ctx.Err() == nil {
+				p.handleError(err)
+			}
+			return nil
+		}
+	}
+	lastSend := time.Now()
+	timeout := time.NewTimer(p.rttLimit)
+	defer timeout.Stop()
+	remaining := p.count - (preload - 1)
+	var report <-chan time.Time
+	if p.reportInterval > 0 {
+		ticker := time.NewTicker(p.reportInterval)
+		defer ticker.Stop()
+		report = ticker.C
+	}
+	var deadline <-chan time.Time
+	if p.softDeadline > 0 {
+		timer := time.NewTimer(p.softDeadline)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	for {
+		// wait for the answer to the last echo request
+	await:
+		for {
+			select {
+			case <-ctx.Done():
+				p.drainSent(ctx, ping)
+				return nil
+			case <-report:
+				p.handleReport()
+			case <-deadline:
+				p.handleDeadline()
+			case <-timeout.C:
+				if p.attempts < p.retries {
+					if err := p.resendEcho(ctx, cn); err != nil {
+						if ctx.Err() == nil {
+							p.handleError(err)
+						}
+						return nil
+					}
+					timeout.Reset(p.rttLimit)
+					continue
+				}
+				p.handleTimeout()
+				break await
+			case res := <-ping:
+				if res.malformed {
+					// garbage doesn't answer the echo request, keep waiting
+					p.handleError(res.err)
+					continue
+				}
+				if res.err == nil {
+					// keep waiting for the answer to the last echo request,
+					// anything else is only reported, e.g. duplicates in
+					// broadcast mode or late replies to earlier requests
+					if pkt := p.handleMsg(res); !p.answersLast(pkt, res.msg) {
+						continue
+					}
+					if p.stopOnReply && p.received > 0 {
+						return nil
+					}
+				} else {
+					p.handleError(res.err)
+				}
+				stopTimer(timeout)
+				break await
+			}
+		}
+		// requests without a reply in time are lost
+		p.expireSent()
+
+		if p.count > 0 {
+			remaining--
+			if remaining == 0 {
+				p.drainSent(ctx, ping)
+				return nil
+			}
+		}
+		if !p.nextSize() {
+			p.drainSent(ctx, ping)
+			return nil
+		}
+
+		// pace the next echo request from the send time of the last one
+		pace := time.NewTimer(time.Until(lastSend.Add(p.nextInterval())))
+	sleep:
+		for {
+			select {
+			case <-ctx.Done():
+				pace.Stop()
+				p.drainSent(ctx, ping)
+				return nil
+			case <-report:
+				p.handleReport()
+			case <-deadline:
+				p.handleDeadline()
+			case <-pace.C:
+				break sleep
+			}
+		}
+
+		// waiting for the rate limit doesn't count towards the timeout
+		if err := p.sendEcho(ctx, cn); err != nil {
+			if ctx.Err() == nil {
+				p.handleError(err)
+			}
+			return nil
+		}
+		lastSend = time.Now()
+		timeout.Reset(p.rttLimit)
+	}
+}
+
+// drainSent waits up to the drain time for replies to echo requests still in
+// flight when the run ends, e.g. those of a preload burst or the last one at
+// the deadline, so they aren't counted as lost. A run cancelled rather than
+// past its deadline, e.g. interrupted by the user, ends right away.
+func (p *Pinger) drainSent(ctx context.Context, ping <-chan recvResult) {
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return
+	}
+	limit := p.drain
+	if limit < 0 {
+		limit = p.rttLimit
+	}
+	p.expireSent()
+	if limit == 0 || len(p.sentAt) == 0 {
+		return
+	}
+
+	timer := time.NewTimer(limit)
+	defer timer.Stop()
+	for len(p.sentAt) > 0 {
+		select {
+		case <-timer.C:
+			return
+		case res := <-ping:
+			if res.err != nil {
+				p.handleError(res.err)
+				if !res.malformed {
+					return
+				}
+				continue
+			}
+			p.handleMsg(res)
+			p.expireSent()
+		}
+	}
+}
+
+// stopTimer stops `t` and drains its channel, so it can be reset safely.
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
@@ -0,0 +1,729 @@
+package pinger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// newTestPinger returns a Pinger for 127.0.0.1 which resolves nothing.
+func newTestPinger(t *testing.T, opts ...Option) *Pinger {
+	t.Helper()
+	p, err := New("127.0.0.1", append([]Option{WithNumeric(true)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// fakeSend does the bookkeeping of sending echo request `seq` at `at`
+// without a connection and returns its echo data.
+func fakeSend(p *Pinger, seq int, at time.Time) []byte {
+	p.seqnum = seq
+	data := p.payload(at)
+	p.sentAt[seq] = at
+	p.sentData[seq] = data
+	delete(p.replied, seq)
+	p.sent++
+	return data
+}
+
+// echoReply returns the message of an echo reply to `p` as received at `at`.
+func echoReply(t *testing.T, p *Pinger, seq int, data []byte, at time.Time) recvResult {
+	t.Helper()
+	msg := &icmp.Message{
+		Type: ipv4.ICMPTypeEchoReply,
+		Body: &icmp.Echo{ID: p.id, Seq: seq, Data: data},
+	}
+	raw, err := msg.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := icmp.ParseMessage(1, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recvResult{msg: parsed, raw: raw, peer: &net.IPAddr{IP: p.dst.IP}, ttl: 64, at: at}
+}
+
+// hand-rolled encoding replaced by encoding/binary, kept to prove both agree
+func loopTimeToBytes(t time.Time) []byte {
+	bytes := make([]byte, 8)
+	nsecs := t.UnixNano()
+	for i := 0; i < 8; i++ {
+		bytes[i] = byte(0xff & (nsecs >> ((7 - i) * 8)))
+	}
+	return bytes
+}
+
+func loopBytesToTime(bytes []byte) time.Time {
+	nsecs := int64(0)
+	for i := 0; i < 8; i++ {
+		nsecs += int64(bytes[i]) << ((7 - i) * 8)
+	}
+	return time.Unix(nsecs/1000000000, nsecs%1000000000)
+}
+
+func TestTimestampRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+	}{
+		{"epoch", time.Unix(0, 0)},
+		{"one nanosecond after epoch", time.Unix(0, 1)},
+		{"one nanosecond before epoch", time.Unix(0, -1)},
+		{"one second before epoch", time.Unix(-1, 0)},
+		{"before epoch with nanoseconds", time.Date(1969, 7, 20, 20, 17, 40, 123456789, time.UTC)},
+		{"nanoseconds", time.Date(2024, 2, 29, 23, 59, 59, 999999999, time.UTC)},
+		{"odd nanoseconds", time.Unix(1700000000, 1)},
+		{"other time zone", time.Date(2001, 9, 9, 1, 46, 40, 500, time.FixedZone("UTC+5", 5*60*60))},
+		{"latest encodable", time.Unix(0, 1<<63-1)},
+		{"earliest encodable", time.Unix(0, -1<<63)},
+		{"now", time.Now()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := timeToBytes(tt.t)
+			if len(b) != timestampLen {
+				t.Fatalf("timeToBytes gave %d bytes, want %d", len(b), timestampLen)
+			}
+			got, ok := bytesToTime(b)
+			if !ok {
+				t.Fatalf("bytesToTime(%x) failed", b)
+			}
+			if !got.Equal(tt.t) {
+				t.Errorf("round trip gave %v, want %v", got, tt.t)
+			}
+
+			if loop := loopTimeToBytes(tt.t); string(loop) != string(b) {
+				t.Errorf("timeToBytes gave %x, the loop %x", b, loop)
+			}
+			if loop := loopBytesToTime(b); !loop.Equal(got) {
+				t.Errorf("bytesToTime gave %v, the loop %v", got, loop)
+			}
+		})
+	}
+}
+
+func TestDuplicateReply(t *testing.T) {
+	p := newTestPinger(t)
+	sent := time.Now()
+	data := fakeSend(p, 7, sent)
+	res := echoReply(t, p, 7, data, sent.Add(time.Millisecond))
+
+	var first, second Packet
+	p.handleEchoReply(res.msg, &first, false, res.at)
+	p.handleEchoReply(res.msg, &second, false, res.at.Add(time.Millisecond))
+
+	if first.Dup {
+		t.Error("first reply marked as duplicate")
+	}
+	if first.RTT != time.Millisecond {
+		t.Errorf("first reply RTT = %s, want 1ms", first.RTT)
+	}
+	if !second.Dup {
+		t.Error("second reply not marked as duplicate")
+	}
+	stats := p.statistics()
+	if stats.Received != 1 || stats.Duplicates != 1 {
+		t.Errorf("Received = %d, Duplicates = %d, want 1 and 1", stats.Received, stats.Duplicates)
+	}
+}
+
+func TestOutOfOrderReply(t *testing.T) {
+	p := newTestPinger(t)
+	start := time.Now()
+	data := make(map[int][]byte)
+	for seq := 1; seq <= 3; seq++ {
+		data[seq] = fakeSend(p, seq, start.Add(time.Duration(seq)*time.Second))
+	}
+
+	outOfOrder := make(map[int]bool)
+	for i, seq := range []int{1, 3, 2} {
+		res := echoReply(t, p, seq, data[seq], start.Add(time.Duration(4+i)*time.Second))
+		var pkt Packet
+		p.handleEchoReply(res.msg, &pkt, false, res.at)
+		outOfOrder[seq] = pkt.OutOfOrder
+	}
+
+	for seq, want := range map[int]bool{1: false, 2: true, 3: false} {
+		if outOfOrder[seq] != want {
+			t.Errorf("reply %d OutOfOrder = %t, want %t", seq, outOfOrder[seq], want)
+		}
+	}
+	stats := p.statistics()
+	if stats.Received != 3 || stats.OutOfOrder != 1 {
+		t.Errorf("Received = %d, OutOfOrder = %d, want 3 and 1", stats.Received, stats.OutOfOrder)
+	}
+}
+
+// loopbackConn returns a packetConn over a UDP socket on 127.0.0.1, which
+// reads whatever is sent to its address the way an ICMP endpoint does, and
+// a socket to send from.
+func loopbackConn(t *testing.T) (*packetConn, net.PacketConn) {
+	t.Helper()
+	c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { peer.Close() })
+	return &packetConn{PacketConn: c, p4: ipv4.NewPacketConn(c)}, peer
+}
+
+func TestRecvSkipsMalformed(t *testing.T) {
+	p := newTestPinger(t)
+	cn, peer := loopbackConn(t)
+	data := fakeSend(p, 1, time.Now())
+	valid := echoReply(t, p, 1, data, time.Now()).raw
+	messages := [][]byte{
+		{0, 0},          // shorter than the ICMP header
+		{0, 0, 0, 0, 1}, // echo reply cut off in the identifier
+		valid,
+	}
+	for _, m := range messages {
+		if _, err := peer.WriteTo(m, cn.LocalAddr()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := make(chan recvResult)
+	done := make(chan struct{})
+	go func() {
+		p.recvEchoReply(ctx, cn, ch, p.recvBufSize())
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	for i := range messages {
+		var res recvResult
+		select {
+		case res = <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message %d not delivered", i)
+		}
+		if i < len(messages)-1 {
+			var parseErr *ParseError
+			if !res.malformed || !errors.As(res.err, &parseErr) {
+				t.Errorf("message %d: got %+v, want a malformed result", i, res)
+			}
+			continue
+		}
+		if res.err != nil {
+			t.Fatalf("valid reply: %v", res.err)
+		}
+		if echo, ok := res.msg.Body.(*icmp.Echo); !ok || echo.ID != p.id || echo.Seq != 1 {
+			t.Errorf("valid reply: got %+v, want echo reply 1", res.msg.Body)
+		}
+	}
+}
+
+func TestRecvBufSize(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want int
+	}{
+		{"default size", nil, minRecvBufSize},
+		{"largest under the minimum", []Option{WithSize(1432)}, 1500},
+		{"just over the minimum", []Option{WithSize(1433)}, 1501},
+		{"large", []Option{WithSize(1400)}, 1500},
+		{"jumbo", []Option{WithSize(8972)}, 8972 + icmpHeaderLen + maxIPv4HeaderLen},
+		{"sweep up to the largest", []Option{WithSize(56), WithSweep(0, 4000, 100)}, 4068},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPinger(t, tt.opts...)
+			if got := p.recvBufSize(); got != tt.want {
+				t.Errorf("recvBufSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// skipIfDenied skips the test if `err` tells that the socket needs
+// privileges the test doesn't have.
+func skipIfDenied(t *testing.T, err error) {
+	t.Helper()
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("no privileges for the socket: %v", err)
+	}
+}
+
+func TestLargeEchoLoopback(t *testing.T) {
+	const size = 1400
+	var bytes int
+	p := newTestPinger(t,
+		WithSize(size),
+		WithCount(1),
+		WithTimeout(2*time.Second),
+		OnRecv(func(pkt Packet) {
+			bytes = pkt.Bytes
+		}),
+	)
+	stats, err := p.Run(context.Background())
+	skipIfDenied(t, err)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Received != 1 {
+		t.Fatalf("Received = %d, want 1", stats.Received)
+	}
+	if bytes != size+icmpHeaderLen {
+		t.Errorf("reply of %d bytes, want %d", bytes, size+icmpHeaderLen)
+	}
+}
+
+// echoConn is the connection half of loopbackConn which answers the echo
+// requests written to it itself: `answer` decides whether one gets an echo
+// reply, which arrives to be read like one from the network. With `late` the
+// others are answered after all, once the next request is written.
+type echoConn struct {
+	net.PacketConn
+	t      *testing.T
+	peer   net.PacketConn
+	answer func(echo *icmp.Echo) bool
+
+	late     bool
+	withheld *icmp.Echo
+}
+
+func (c *echoConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	req, err := icmp.ParseMessage(1, b)
+	if err != nil {
+		c.t.Errorf("parsing written request: %v", err)
+		return len(b), nil
+	}
+	echo, ok := req.Body.(*icmp.Echo)
+	if !ok {
+		return len(b), nil
+	}
+	if c.withheld != nil {
+		if err := c.reply(c.withheld); err != nil {
+			return 0, err
+		}
+		c.withheld = nil
+	}
+	if !c.answer(echo) {
+		if c.late {
+			c.withheld = echo
+		}
+		return len(b), nil
+	}
+	if err := c.reply(echo); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// reply sends the echo reply to `echo`.
+func (c *echoConn) reply(echo *icmp.Echo) error {
+	raw, err := (&icmp.Message{Type: ipv4.ICMPTypeEchoReply, Body: echo}).Marshal(nil)
+	if err != nil {
+		return err
+	}
+	_, err = c.peer.WriteTo(raw, c.LocalAddr())
+	return err
+}
+
+// answeringConn returns a connection over loopbackConn answering echo
+// requests as `answer` decides, no privileges needed.
+func answeringConn(t *testing.T, answer func(echo *icmp.Echo) bool) *packetConn {
+	t.Helper()
+	cn, peer := loopbackConn(t)
+	cn.PacketConn = &echoConn{PacketConn: cn.PacketConn, t: t, peer: peer, answer: answer}
+	return cn
+}
+
+func TestOnRecvFires(t *testing.T) {
+	var got []Packet
+	p := newTestPinger(t,
+		WithCount(3),
+		WithStartSeq(1),
+		WithInterval(10*time.Millisecond),
+		WithTimeout(time.Second),
+		OnRecv(func(pkt Packet) {
+			got = append(got, pkt)
+		}),
+	)
+	cn := answeringConn(t, func(*icmp.Echo) bool { return true })
+
+	if err := pingLoop(context.Background(), p, cn); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("OnRecv called %d times, want 3", len(got))
+	}
+	for i, pkt := range got {
+		if pkt.Seq != i+1 || pkt.RTT <= 0 || pkt.Dup || pkt.Err != nil {
+			t.Errorf("packet %d: Seq %d, RTT %s, Dup %t, Err %v", i, pkt.Seq, pkt.RTT, pkt.Dup, pkt.Err)
+		}
+	}
+}
+
+func TestPacketsDropsWhenFull(t *testing.T) {
+	ch := make(chan Packet, 1)
+	p := newTestPinger(t,
+		WithCount(3),
+		WithStartSeq(1),
+		WithInterval(10*time.Millisecond),
+		WithTimeout(time.Second),
+		Packets(ch),
+	)
+	cn := answeringConn(t, func(*icmp.Echo) bool { return true })
+
+	// nobody drains `ch`, the run mustn't wait for that
+	if err := pingLoop(context.Background(), p, cn); err != nil {
+		t.Fatal(err)
+	}
+	stats := p.statistics()
+	if stats.Received != 3 || stats.Dropped != 2 {
+		t.Errorf("Received = %d, Dropped = %d, want 3 and 2", stats.Received, stats.Dropped)
+	}
+	if pkt := <-ch; pkt.Seq != 1 {
+		t.Errorf("delivered reply %d, want 1", pkt.Seq)
+	}
+}
+
+func TestICMPErrorPacket(t *testing.T) {
+	p := newTestPinger(t)
+	fakeSend(p, 1, time.Now())
+	req, err := (&icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: p.id, Seq: 1, Data: p.sentData[1]},
+	}).Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the quoted datagram: an IPv4 header without options, then the request
+	quoted := append(append(make([]byte, 0, ipv4.HeaderLen+len(req)), 0x45), make([]byte, ipv4.HeaderLen-1)...)
+	quoted = append(quoted, req...)
+	msg := &icmp.Message{
+		Type: ipv4.ICMPTypeDestinationUnreachable,
+		Code: 1,
+		Body: &icmp.DstUnreach{Data: quoted},
+	}
+	raw, err := msg.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := icmp.ParseMessage(1, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := net.IPv4(192, 0, 2, 1)
+
+	var got []Packet
+	p.onRecv = func(pkt Packet) { got = append(got, pkt) }
+	p.handleMsg(recvResult{msg: parsed, raw: raw, peer: &net.IPAddr{IP: gateway}, ttl: 64, at: time.Now()})
+
+	if len(got) != 1 {
+		t.Fatalf("OnRecv called %d times, want 1", len(got))
+	}
+	var icmpErr *ICMPError
+	if !errors.As(got[0].Err, &icmpErr) {
+		t.Fatalf("Err = %v, want an *ICMPError", got[0].Err)
+	}
+	if icmpErr.Type != ipv4.ICMPTypeDestinationUnreachable || icmpErr.Code != 1 || !icmpErr.From.Equal(gateway) {
+		t.Errorf("Err = %+v", icmpErr)
+	}
+	if got[0].Seq != 1 {
+		t.Errorf("Seq = %d, want 1", got[0].Seq)
+	}
+}
+
+// fakeResolver knows the addresses of a fixed set of host names.
+type fakeResolver map[string][]string
+
+func (r fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := r[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	var addrs []net.IPAddr
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestResolveVersion(t *testing.T) {
+	resolver := fakeResolver{
+		"v4.example":     {"192.0.2.1"},
+		"v6.example":     {"2001:db8::1"},
+		"mapped.example": {"::ffff:192.0.2.2"},
+	}
+	tests := []struct {
+		name    string
+		host    string
+		isIPv6  bool
+		want    string
+		wantErr string
+	}{
+		{"IPv4-only host under -4", "v4.example", false, "192.0.2.1", ""},
+		{"IPv4-only host under -6", "v4.example", true, "", "v4.example has no IPv6 address, only IPv4 ones"},
+		{"IPv6-only host under -4", "v6.example", false, "", "v6.example has no IPv4 address, only IPv6 ones"},
+		{"IPv6-only host under -6", "v6.example", true, "2001:db8::1", ""},
+		{"IPv4-mapped address under -6", "mapped.example", true, "", "has no IPv6 address"},
+		{"unknown host", "none.example", false, "", "no such host"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(tt.host, WithResolver(resolver), WithIPv6(tt.isIPv6))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("New() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+			if got := p.dst.IP.String(); got != tt.want {
+				t.Errorf("destination %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBadChecksumReply(t *testing.T) {
+	tests := []struct {
+		name   string
+		offset int // of the corrupted byte in the marshalled reply
+	}{
+		{"in the checksum", 2},
+		{"in the send time", icmpHeaderLen + 3},
+		{"in the pattern", icmpHeaderLen + timestampLen + 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestPinger(t, WithVerifyChecksum(true))
+			sent := time.Now()
+			data := fakeSend(p, 1, sent)
+			res := echoReply(t, p, 1, data, sent.Add(time.Millisecond))
+			res.raw[tt.offset] ^= 0x01
+			if res.msg, _ = icmp.ParseMessage(1, res.raw); res.msg == nil {
+				t.Fatal("corrupted reply doesn't parse")
+			}
+
+			pkt := p.parseMsg(res)
+			if !pkt.Corrupt || !pkt.BadSum {
+				t.Errorf("Corrupt = %t, BadSum = %t, want both", pkt.Corrupt, pkt.BadSum)
+			}
+			stats := p.statistics()
+			if stats.Corrupted != 1 || stats.Received != 0 {
+				t.Errorf("Corrupted = %d, Received = %d, want 1 and 0", stats.Corrupted, stats.Received)
+			}
+
+			// the request is still answered by a good reply
+			good := echoReply(t, p, 1, data, sent.Add(2*time.Millisecond))
+			if pkt := p.parseMsg(good); pkt.Corrupt || pkt.RTT != 2*time.Millisecond {
+				t.Errorf("good reply: Corrupt = %t, RTT = %s", pkt.Corrupt, pkt.RTT)
+			}
+			if stats := p.statistics(); stats.Received != 1 {
+				t.Errorf("Received = %d after the good reply, want 1", stats.Received)
+			}
+		})
+	}
+}
+
+func TestShortEchoData(t *testing.T) {
+	for _, size := range []int{0, 4, 7, 8} {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			p := newTestPinger(t, WithSize(size))
+			sent := time.Now()
+			data := fakeSend(p, 1, sent)
+			if len(data) != size {
+				t.Fatalf("echo data of %d bytes, want %d", len(data), size)
+			}
+			// as much of the send time as fits, 4 bytes get half of it
+			if stamp := timeToBytes(sent); string(data[:min(size, timestampLen)]) != string(stamp[:min(size, timestampLen)]) {
+				t.Errorf("echo data %x doesn't start with the send time %x", data, stamp)
+			}
+
+			// the RTT comes from the recorded send time, whatever the data
+			res := echoReply(t, p, 1, data, sent.Add(3*time.Millisecond))
+			var pkt Packet
+			p.handleEchoReply(res.msg, &pkt, false, res.at)
+			if pkt.Corrupt || pkt.RTT != 3*time.Millisecond {
+				t.Errorf("Corrupt = %t, RTT = %s, want false and 3ms", pkt.Corrupt, pkt.RTT)
+			}
+
+			// duplicates are timed from the echoed send time, if it fits
+			var dup Packet
+			p.handleEchoReply(res.msg, &dup, false, sent.Add(5*time.Millisecond))
+			wantDup := time.Duration(0)
+			if size >= timestampLen {
+				wantDup = 5 * time.Millisecond
+			}
+			if !dup.Dup || dup.RTT != wantDup {
+				t.Errorf("duplicate: Dup = %t, RTT = %s, want true and %s", dup.Dup, dup.RTT, wantDup)
+			}
+		})
+	}
+}
+
+func TestShortEchoDataRun(t *testing.T) {
+	for _, size := range []int{0, 4, 7, 8} {
+		t.Run(fmt.Sprintf("%d bytes", size), func(t *testing.T) {
+			var rtts []time.Duration
+			p := newTestPinger(t,
+				WithSize(size),
+				WithCount(2),
+				WithInterval(10*time.Millisecond),
+				WithTimeout(time.Second),
+				OnRecv(func(pkt Packet) {
+					rtts = append(rtts, pkt.RTT)
+				}),
+			)
+			cn := answeringConn(t, func(*icmp.Echo) bool { return true })
+
+			if err := pingLoop(context.Background(), p, cn); err != nil {
+				t.Fatal(err)
+			}
+			if stats := p.statistics(); stats.Received != 2 || stats.Corrupted != 0 {
+				t.Errorf("Received = %d, Corrupted = %d, want 2 and 0", stats.Received, stats.Corrupted)
+			}
+			for i, rtt := range rtts {
+				if rtt <= 0 || rtt > time.Second {
+					t.Errorf("reply %d: RTT %s", i, rtt)
+				}
+			}
+		})
+	}
+}
+
+func TestPacing(t *testing.T) {
+	const slack = 40 * time.Millisecond
+	odd := func(echo *icmp.Echo) bool { return echo.Seq%2 == 1 }
+	none := func(*icmp.Echo) bool { return false }
+	tests := []struct {
+		name     string
+		interval time.Duration
+		timeout  time.Duration
+		answer   func(echo *icmp.Echo) bool
+		late     bool // unanswered requests get their reply during the next one's wait
+		// gap before the request after a reply and after a timeout
+		afterReply, afterTimeout time.Duration
+	}{
+		{"timeout within the interval", 100 * time.Millisecond, 50 * time.Millisecond, odd, false, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"timeout beyond the interval", 30 * time.Millisecond, 80 * time.Millisecond, odd, false, 30 * time.Millisecond, 80 * time.Millisecond},
+		{"late replies to earlier requests", 30 * time.Millisecond, 80 * time.Millisecond, none, true, 30 * time.Millisecond, 80 * time.Millisecond},
+		{"late replies between replies", 30 * time.Millisecond, 80 * time.Millisecond, odd, true, 30 * time.Millisecond, 80 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sends []time.Time
+			var timeouts []int
+			p := newTestPinger(t,
+				WithCount(5),
+				WithStartSeq(1),
+				WithInterval(tt.interval),
+				WithTimeout(tt.timeout),
+				WithDrain(0),
+				OnSend(func(int) {
+					sends = append(sends, time.Now())
+				}),
+				OnTimeout(func(seq int) {
+					timeouts = append(timeouts, seq)
+				}),
+			)
+			cn := answeringConn(t, tt.answer)
+			cn.PacketConn.(*echoConn).late = tt.late
+
+			if err := pingLoop(context.Background(), p, cn); err != nil {
+				t.Fatal(err)
+			}
+			if len(sends) != 5 {
+				t.Fatalf("%d echo requests sent, want 5", len(sends))
+			}
+			var wantTimeouts []int
+			for seq := 1; seq <= 5; seq++ {
+				if !tt.answer(&icmp.Echo{Seq: seq}) {
+					wantTimeouts = append(wantTimeouts, seq)
+				}
+			}
+			for i := 1; i < len(sends); i++ {
+				want := tt.afterReply
+				if !tt.answer(&icmp.Echo{Seq: i}) {
+					want = tt.afterTimeout
+				}
+				if gap := sends[i].Sub(sends[i-1]); gap < want || gap > want+slack {
+					t.Errorf("request %d sent %s after the previous one, want %s", i+1, gap, want)
+				}
+			}
+			// late replies answer requests counted as lost already
+			if !slices.Equal(timeouts, wantTimeouts) {
+				t.Errorf("timeouts of %v, want %v", timeouts, wantTimeouts)
+			}
+			if stats := p.statistics(); stats.Received != 5-len(wantTimeouts) || stats.Transmitted != 5 {
+				t.Errorf("%d/%d replies, want %d/5", stats.Received, stats.Transmitted, 5-len(wantTimeouts))
+			}
+		})
+	}
+}
+
+func TestClaimsShortQuotedHeader(t *testing.T) {
+	p := newTestPinger(t)
+	// the quoted request, if the header length were taken at its word
+	quote := func(first byte, hdrLen, size int) []byte {
+		data := make([]byte, size)
+		data[0] = first
+		if hdrLen+8 <= size {
+			data[hdrLen+4], data[hdrLen+5] = byte(p.id>>8), byte(p.id)
+		}
+		return data
+	}
+	peer := &net.IPAddr{IP: net.IPv4(192, 0, 2, 1)}
+
+	for ihl := 1; ihl < 5; ihl++ {
+		for size := 12; size < 20; size++ {
+			data := quote(0x40|byte(ihl), ihl*4, size)
+			for _, msg := range []*icmp.Message{
+				{Type: ipv4.ICMPTypeDestinationUnreachable, Body: &icmp.DstUnreach{Data: data}},
+				{Type: ipv4.ICMPTypeTimeExceeded, Body: &icmp.TimeExceeded{Data: data}},
+			} {
+				if p.claims(msg, peer) {
+					t.Errorf("IHL %d, %d bytes: claimed", ihl, size)
+				}
+			}
+		}
+	}
+
+	// a header of another IP version doesn't quote our requests either
+	data := quote(0x65, ipv4.HeaderLen, ipv4.HeaderLen+8)
+	copy(data[16:20], p.dst.IP.To4())
+	if p.claims(&icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Body: &icmp.DstUnreach{Data: data}}, peer) {
+		t.Error("version 6 header claimed")
+	}
+	data[0] = 0x45
+	if !p.claims(&icmp.Message{Type: ipv4.ICMPTypeDestinationUnreachable, Body: &icmp.DstUnreach{Data: data}}, peer) {
+		t.Error("complete quote of our request not claimed")
+	}
+
+	if ip := quotedDst(make([]byte, 19), false); ip != nil {
+		t.Errorf("quotedDst of a short IPv4 header = %v, want nil", ip)
+	}
+	if ip := quotedDst(make([]byte, 39), true); ip != nil {
+		t.Errorf("quotedDst of a short IPv6 header = %v, want nil", ip)
+	}
+}
@@ -0,0 +1,41 @@
+package pinger
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// datagramPortInUse reports whether another ICMP datagram socket of the IP
+// version is bound to local `port`. Linux lets such sockets share a port,
+// which then steal each other's replies, so ports are checked before bind.
+func datagramPortInUse(isIPv6 bool, port int) bool {
+	path := "/proc/net/icmp"
+	if isIPv6 {
+		path = "/proc/net/icmp6"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// e.g. "12: 00000000:1093 00000000:0000 07 ..."
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.ParseUint(hexPort, 16, 16); err == nil && int(n) == port {
+			return true
+		}
+	}
+	return false
+}
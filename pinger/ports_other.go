@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package pinger
+
+// datagramPortInUse reports false, the kernel refuses taken ports on bind.
+func datagramPortInUse(isIPv6 bool, port int) bool {
+	return false
+}
@@ -0,0 +1,58 @@
+package pinger
+
+import "net"
+
+// IPv4 options (RFC 791).
+const (
+	ipOptEnd         = 0
+	ipOptNop         = 1
+	ipOptRecordRoute = 7
+)
+
+// MaxRecordedHops is the number of addresses fitting into the Record Route
+// option, as IPv4 options take at most 40 bytes. Longer routes lose their
+// later hops.
+const MaxRecordedHops = 9
+
+// recordRouteOption returns an empty Record Route option with room for
+// MaxRecordedHops addresses, padded to the 4-byte boundary options end on.
+func recordRouteOption() []byte {
+	opt := make([]byte, 3+4*MaxRecordedHops+1)
+	opt[0] = ipOptRecordRoute
+	opt[1] = 3 + 4*MaxRecordedHops
+	opt[2] = 4 // pointer to the first free slot, 1-based
+	return opt
+}
+
+// recordedRoute extracts the addresses filled into a Record Route option
+// among the IPv4 header options `opts`, nil if there is none.
+func recordedRoute(opts []byte) []net.IP {
+	for len(opts) > 0 {
+		switch opts[0] {
+		case ipOptEnd:
+			return nil
+		case ipOptNop:
+			opts = opts[1:]
+			continue
+		}
+		if len(opts) < 2 || opts[1] < 2 || int(opts[1]) > len(opts) {
+			return nil
+		}
+		opt := opts[:opts[1]]
+		opts = opts[opts[1]:]
+		if opt[0] != ipOptRecordRoute || len(opt) < 3 {
+			continue
+		}
+
+		end := int(opt[2]) - 1
+		if end > len(opt) {
+			end = len(opt)
+		}
+		route := []net.IP{}
+		for i := 3; i+4 <= end; i += 4 {
+			route = append(route, net.IP(append([]byte(nil), opt[i:i+4]...)))
+		}
+		return route
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package pinger
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// sharedSocketBuffer is the receive buffer asked for on shared connections,
+// which take the replies of many destinations at once.
+const sharedSocketBuffer = 4 << 20
+
+// SharedConn is a single ICMP connection serving many Pingers, see
+// WithSharedConn. Replies are demultiplexed to the Pingers by ICMP
+// identifier and source address, so hundreds of destinations need a single
+// file descriptor.
+type SharedConn struct {
+	cn     *packetConn
+	isIPv6 bool
+	udp    bool
+	id     int // identifier the kernel gives echo requests of datagram sockets
+	proto  int
+
+	mu      sync.Mutex
+	subs    map[*subscriber]bool
+	bufSize int // largest message any subscriber expects
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// subscriber is a Pinger receiving through a SharedConn.
+type subscriber struct {
+	p    *Pinger
+	ch   chan recvResult
+	done <-chan struct{}
+}
+
+// NewSharedConn opens a connection of the IP version `isIPv6` to share.
+// Socket options among `opts`, i.e. WithUnprivileged, WithLocalPort,
+// WithTTL, WithTOS, WithDontFragment, WithProtocol and WithKernelTimestamps,
+// apply to the connection and thus to all Pingers using it, the others are
+// ignored.
+func NewSharedConn(isIPv6 bool, opts ...Option) (*SharedConn, error) {
+	t := &Pinger{ttl: 100, size: 56, preload: 1}
+	for _, opt := range opts {
+		opt(t)
+	}
+	t.isIPv6 = isIPv6
+
+	cn, err := t.getConnection(t.network(), "")
+	if err != nil {
+		return nil, err
+	}
+	// best effort, like for preload bursts
+	if rb, ok := cn.PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+		rb.SetReadBuffer(sharedSocketBuffer)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &SharedConn{
+		cn:      cn,
+		isIPv6:  isIPv6,
+		udp:     t.udp,
+		id:      t.id,
+		proto:   t.proto,
+		subs:    make(map[*subscriber]bool),
+		bufSize: minRecvBufSize,
+		cancel:  cancel,
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.dispatch(ctx)
+	}()
+
+	return s, nil
+}
+
+// Close stops receiving and closes the connection. Pingers must not use it
+// any more.
+func (s *SharedConn) Close() error {
+	s.cancel()
+	s.wg.Wait()
+	return s.cn.Close()
+}
+
+// attach checks that `p` fits the connection and takes over its identifier.
+func (s *SharedConn) attach(p *Pinger) error {
+	if p.isIPv6 != s.isIPv6 || p.udp != s.udp {
+		return errors.New("Shared connection error: IP version or socket type differs")
+	}
+	if s.udp {
+		p.id = s.id
+	}
+	return nil
+}
+
+// subscribe makes the messages concerning `p` arrive on the returned channel
+// until the returned function is called or `ctx` is done.
+func (s *SharedConn) subscribe(ctx context.Context, p *Pinger) (<-chan recvResult, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	sub := &subscriber{p: p, ch: make(chan recvResult, 16), done: ctx.Done()}
+
+	s.mu.Lock()
+	s.subs[sub] = true
+	if size := p.recvBufSize(); size > s.bufSize {
+		s.bufSize = size
+	}
+	s.mu.Unlock()
+
+	return sub.ch, func() {
+		cancel()
+		s.mu.Lock()
+		delete(s.subs, sub)
+		s.mu.Unlock()
+	}
+}
+
+// dispatch reads messages and passes each to the subscribers it concerns
+// until reading fails or `ctx` is done.
+func (s *SharedConn) dispatch(ctx context.Context) {
+	for ctx.Err() == nil {
+		s.mu.Lock()
+		bufSize := s.bufSize
+		s.mu.Unlock()
+
+		res, timeout := readMessage(s.cn, s.isIPv6, s.proto, bufSize)
+		if timeout {
+			continue
+		}
+		// a malformed message can't be told apart, nobody gets it
+		if res.malformed {
+			continue
+		}
+
+		s.mu.Lock()
+		var subs []*subscriber
+		for sub := range s.subs {
+			if res.err != nil || sub.p.claims(res.msg, res.peer) {
+				subs = append(subs, sub)
+			}
+		}
+		s.mu.Unlock()
+
+		for _, sub := range subs {
+			select {
+			case sub.ch <- res:
+			case <-sub.done:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if res.err != nil {
+			return
+		}
+	}
+}
+
+// claims reports whether `msg` from `peer` may concern the Pinger: echo
+// replies with its identifier from its destination, and errors quoting such
+// an echo request. Other messages, e.g. redirects, are sorted out by the
+// Pinger itself.
+func (p *Pinger) claims(msg *icmp.Message, peer net.Addr) bool {
+	var quoted []byte
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		if !isEchoReply(msg.Type) || body.ID != p.id {
+			return false
+		}
+		return p.broadcast || p.dst.IP.IsMulticast() || addrIP(peer).Equal(p.dst.IP)
+	case *icmp.TimeExceeded:
+		quoted = body.Data
+	case *icmp.DstUnreach:
+		quoted = body.Data
+	case *icmp.PacketTooBig:
+		quoted = body.Data
+	case *icmp.ParamProb:
+		quoted = body.Data
+	default:
+		return true
+	}
+
+	id, _, ok := quotedEcho(quoted, p.isIPv6)
+	return ok && id == p.id && quotedDst(quoted, p.isIPv6).Equal(p.dst.IP)
+}
+
+// quotedDst extracts the destination address from the IP header quoted in
+// an ICMP error message, nil if the header is cut short.
+func quotedDst(data []byte, isIPv6 bool) net.IP {
+	if isIPv6 {
+		if len(data) < ipv6.HeaderLen {
+			return nil
+		}
+		return net.IP(data[24:40])
+	}
+	if len(data) < ipv4.HeaderLen {
+		return nil
+	}
+	return net.IP(data[16:20])
+}
@@ -0,0 +1,18 @@
+package pinger
+
+import "syscall"
+
+// Socket options missing from package syscall on darwin.
+const (
+	sysIP_DONTFRAG   = 0x1c
+	sysIPV6_DONTFRAG = 0x3e
+)
+
+// setDontFragment turns off fragmentation of outgoing packets, which for
+// IPv4 means setting the Don't Fragment bit.
+func setDontFragment(c *packetConn, isIPv6 bool) error {
+	if isIPv6 {
+		return setsockoptInt(c, syscall.IPPROTO_IPV6, sysIPV6_DONTFRAG, 1)
+	}
+	return setsockoptInt(c, syscall.IPPROTO_IP, sysIP_DONTFRAG, 1)
+}
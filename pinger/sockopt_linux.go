@@ -0,0 +1,12 @@
+package pinger
+
+import "syscall"
+
+// setDontFragment turns off fragmentation of outgoing packets, which for
+// IPv4 means setting the Don't Fragment bit.
+func setDontFragment(c *packetConn, isIPv6 bool) error {
+	if isIPv6 {
+		return setsockoptInt(c, syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+	}
+	return setsockoptInt(c, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+}
@@ -0,0 +1,20 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package pinger
+
+import "errors"
+
+var errSockoptUnsupported = errors.New("not supported on this platform")
+
+func setDontFragment(c *packetConn, isIPv6 bool) error {
+	return errSockoptUnsupported
+}
+
+func setBroadcast(c *packetConn) error {
+	return errSockoptUnsupported
+}
+
+func setRecordRoute(c *packetConn) error {
+	return errSockoptUnsupported
+}
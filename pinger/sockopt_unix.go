@@ -0,0 +1,62 @@
+//go:build darwin || linux
+// +build darwin linux
+
+package pinger
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// setsockoptInt sets an integer socket option on the underlying socket.
+func setsockoptInt(c *packetConn, level, name, value int) error {
+	sc, ok := c.PacketConn.(syscall.Conn)
+	if !ok {
+		return errors.New("socket options are not supported by the connection")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), level, name, value)
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+// setBroadcast allows sending to broadcast addresses.
+func setBroadcast(c *packetConn) error {
+	return setsockoptInt(c, syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+}
+
+// setsockoptBytes sets a socket option taking a byte string on the underlying
+// socket.
+func setsockoptBytes(c *packetConn, level, name int, value []byte) error {
+	sc, ok := c.PacketConn.(syscall.Conn)
+	if !ok {
+		return errors.New("socket options are not supported by the connection")
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptString(int(fd), level, name, string(value))
+	}); err != nil {
+		return err
+	}
+	return os.NewSyscallError("setsockopt", serr)
+}
+
+// setRecordRoute makes outgoing IPv4 packets carry an empty Record Route
+// option.
+func setRecordRoute(c *packetConn) error {
+	return setsockoptBytes(c, syscall.IPPROTO_IP, syscall.IP_OPTIONS, recordRouteOption())
+}
@@ -0,0 +1,149 @@
+package pinger
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+// Statistics is the summary of a Pinger run.
+type Statistics struct {
+	Host        string
+	IP          net.IP
+	Transmitted int             // number of echo requests sent
+	Received    int             // number of echo replies, without duplicates
+	Duplicates  int             // number of duplicate echo replies
+	Corrupted   int             // number of echo replies with altered data
+	OutOfOrder  int             // number of echo replies arriving out of order
+	Errors      int             // number of ICMP error messages
+	Redirects   int             // number of ICMP Redirect messages
+	Problems    int             // number of Parameter Problem and Source Quench messages
+	Retried     int             // number of echo requests sent only after retrying
+	Resent      int             // number of echo requests resent after a timeout
+	Dropped     int             // number of received messages dropped by Packets, its channel being full
+	PacketLoss  float64         // percentage of echo requests without reply
+	RTTs        []time.Duration // round trip times of echo replies
+	MinRTT      time.Duration
+	AvgRTT      time.Duration
+	MaxRTT      time.Duration
+	MdevRTT     time.Duration // mean absolute deviation from AvgRTT
+	Jitter      time.Duration // mean absolute difference of successive RTTs
+	Sweep       []SweepResult // results per data size in sweep mode
+	Responders  []net.IP      // distinct sources of echo replies to broadcasts and multicasts
+}
+
+// SweepResult is the outcome of the echo request of one data size in sweep
+// mode.
+type SweepResult struct {
+	Size    int // number of ICMP data bytes
+	Replied bool
+	RTT     time.Duration // round trip time, if replied
+}
+
+func (p *Pinger) statistics() Statistics {
+	stats := Statistics{
+		Host:        p.host,
+		IP:          p.dst.IP,
+		Transmitted: p.sent,
+		Received:    p.received,
+		Duplicates:  p.duplicates,
+		Corrupted:   p.corrupted,
+		OutOfOrder:  p.outOfOrder,
+		Errors:      p.errors,
+		Redirects:   p.redirects,
+		Problems:    p.problems,
+		Retried:     p.retried,
+		Resent:      p.resent,
+		Dropped:     p.dropped,
+		RTTs:        p.rtts,
+		Sweep:       p.sweep,
+		Responders:  p.responders,
+	}
+	if p.sent > 0 {
+		stats.PacketLoss = float64(p.sent-p.received) * 100 / float64(p.sent)
+	}
+	stats.MinRTT, stats.AvgRTT, stats.MaxRTT, stats.MdevRTT = rttSummary(p.rtts)
+	stats.Jitter = rttJitter(p.rtts)
+
+	return stats
+}
+
+// ExitCode returns the exit code of the standard ping for the run: 0 if any
+// echo reply was received, 1 if none was.
+func (s Statistics) ExitCode() int {
+	if s.Received > 0 {
+		return 0
+	}
+	return 1
+}
+
+// Percentile returns the `p`th percentile (0-100) of the round trip times,
+// interpolating linearly between the closest ranks of the sorted samples.
+// It is 0 without any.
+func (s Statistics) Percentile(p float64) time.Duration {
+	if len(s.RTTs) == 0 {
+		return 0
+	}
+
+	rtts := slices.Clone(s.RTTs)
+	slices.Sort(rtts)
+	rank := p / 100 * float64(len(rtts)-1)
+	lo := int(rank)
+	if lo >= len(rtts)-1 {
+		return rtts[len(rtts)-1]
+	}
+	frac := rank - float64(lo)
+
+	return rtts[lo] + time.Duration(frac*float64(rtts[lo+1]-rtts[lo]))
+}
+
+// rttSummary returns min/avg/max/mdev of the round trip times.
+// mdev is the mean absolute deviation from the average.
+func rttSummary(rtts []time.Duration) (min, avg, max, mdev time.Duration) {
+	if len(rtts) == 0 {
+		return
+	}
+
+	min, max = rtts[0], rtts[0]
+	sum := time.Duration(0)
+	for _, rtt := range rtts {
+		if rtt < min {
+			min = rtt
+		}
+		if rtt > max {
+			max = rtt
+		}
+		sum += rtt
+	}
+	avg = sum / time.Duration(len(rtts))
+
+	dev := time.Duration(0)
+	for _, rtt := range rtts {
+		dev += absDuration(rtt - avg)
+	}
+	mdev = dev / time.Duration(len(rtts))
+
+	return
+}
+
+// rttJitter returns the mean absolute difference between successive round trip
+// times, the interarrival jitter of RFC 3550 without its smoothing.
+func rttJitter(rtts []time.Duration) time.Duration {
+	if len(rtts) < 2 {
+		return 0
+	}
+
+	sum := time.Duration(0)
+	for i := 1; i < len(rtts); i++ {
+		sum += absDuration(rtts[i] - rtts[i-1])
+	}
+
+	return sum / time.Duration(len(rtts)-1)
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
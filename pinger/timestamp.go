@@ -0,0 +1,93 @@
+package pinger
+
+import (
+	"encoding/binary"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// msPerDay is the range of ICMP timestamps, milliseconds since midnight UT.
+const msPerDay = 24 * 60 * 60 * 1000
+
+// nonStandardTimestamp marks timestamps which are not milliseconds since
+// midnight UT (RFC 792).
+const nonStandardTimestamp = 1 << 31
+
+// timestampBodyLen is the length of a Timestamp message body: identifier,
+// sequence number and the originate, receive and transmit timestamps.
+const timestampBodyLen = 16
+
+// timestampRequest builds an ICMP Timestamp request (type 13) originated at
+// `t`. x/net has no body type for it, so it is raw.
+func (p *Pinger) timestampRequest(seq int, t time.Time) *icmp.Message {
+	data := make([]byte, timestampBodyLen)
+	binary.BigEndian.PutUint16(data[0:], uint16(p.id))
+	binary.BigEndian.PutUint16(data[2:], uint16(seq))
+	binary.BigEndian.PutUint32(data[4:], msSinceMidnight(t))
+
+	return &icmp.Message{
+		Type: ipv4.ICMPTypeTimestamp,
+		Code: 0,
+		Body: &icmp.RawBody{Data: data},
+	}
+}
+
+// handleTimestampReply fills in `pkt` from the body of a Timestamp Reply
+// (type 14) received at `at` and accounts replies to our requests like echo
+// replies. The clock offset of the remote host is estimated like NTP does,
+// assuming symmetric paths.
+func (p *Pinger) handleTimestampReply(data []byte, pkt *Packet, at time.Time) {
+	if len(data) < timestampBodyLen {
+		return
+	}
+	pkt.ID = int(binary.BigEndian.Uint16(data[0:]))
+	pkt.Seq = int(binary.BigEndian.Uint16(data[2:]))
+	if pkt.ID != p.id {
+		return
+	}
+
+	sentAt, ok := p.sentAt[pkt.Seq]
+	if !ok {
+		if p.replied[pkt.Seq] {
+			pkt.Dup = true
+			p.duplicates++
+		}
+		return
+	}
+	delete(p.sentAt, pkt.Seq)
+	delete(p.sentData, pkt.Seq)
+	p.replied[pkt.Seq] = true
+	pkt.RTT = at.Sub(sentAt)
+	p.addRTT(pkt)
+	p.updateSmoothRTT(pkt.RTT)
+
+	originate := binary.BigEndian.Uint32(data[4:])
+	receive := binary.BigEndian.Uint32(data[8:])
+	transmit := binary.BigEndian.Uint32(data[12:])
+	if (receive|transmit)&nonStandardTimestamp != 0 {
+		// the remote clock isn't in milliseconds since midnight UT
+		return
+	}
+	offset := (msDiff(receive, originate) + msDiff(transmit, msSinceMidnight(at))) / 2
+	pkt.Offset = time.Duration(offset) * time.Millisecond
+	pkt.OffsetKnown = true
+}
+
+// msSinceMidnight converts `t` to an ICMP timestamp.
+func msSinceMidnight(t time.Time) uint32 {
+	return uint32(t.UnixNano() / int64(time.Millisecond) % msPerDay)
+}
+
+// msDiff returns `a - b` of two ICMP timestamps, taking the wrap around at
+// midnight into account.
+func msDiff(a, b uint32) int64 {
+	d := (int64(a) - int64(b)) % msPerDay
+	if d > msPerDay/2 {
+		d -= msPerDay
+	} else if d < -msPerDay/2 {
+		d += msPerDay
+	}
+	return d
+}
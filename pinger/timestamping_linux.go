@@ -0,0 +1,44 @@
+package pinger
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// kernelStampFlags ask for receive timestamps taken by the network card if
+// it can, by the kernel otherwise.
+const kernelStampFlags = unix.SOF_TIMESTAMPING_RX_HARDWARE | unix.SOF_TIMESTAMPING_RAW_HARDWARE |
+	unix.SOF_TIMESTAMPING_RX_SOFTWARE | unix.SOF_TIMESTAMPING_SOFTWARE
+
+// enableKernelTimestamps makes the kernel attach the receive time to every
+// incoming message as a control message (SO_TIMESTAMPING).
+func enableKernelTimestamps(c *packetConn) error {
+	return setsockoptInt(c, unix.SOL_SOCKET, unix.SO_TIMESTAMPING, kernelStampFlags)
+}
+
+// kernelTimestamp extracts the receive time from the control messages
+// `oob`, preferring the hardware timestamp over the software one.
+func kernelTimestamp(oob []byte) (time.Time, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return time.Time{}, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level != unix.SOL_SOCKET || m.Header.Type != unix.SCM_TIMESTAMPING {
+			continue
+		}
+		if len(m.Data) < int(unsafe.Sizeof(unix.ScmTimestamping{})) {
+			continue
+		}
+		stamps := (*unix.ScmTimestamping)(unsafe.Pointer(&m.Data[0]))
+		// software, deprecated, raw hardware
+		for _, i := range []int{2, 0} {
+			if ts := stamps.Ts[i]; ts.Sec != 0 || ts.Nsec != 0 {
+				return time.Unix(ts.Unix()), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package pinger
+
+import (
+	"errors"
+	"time"
+)
+
+func enableKernelTimestamps(c *packetConn) error {
+	return errors.New("kernel timestamps are not supported on this platform")
+}
+
+func kernelTimestamp(oob []byte) (time.Time, bool) {
+	return time.Time{}, false
+}
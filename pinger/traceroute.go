@@ -0,0 +1,111 @@
+package pinger
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// probesPerHop is the number of echo requests sent with the same TTL.
+const probesPerHop = 3
+
+// HopProbe is the outcome of a single traceroute echo request.
+type HopProbe struct {
+	IP  net.IP // responder, nil if the probe got no answer in time
+	RTT time.Duration
+}
+
+// Hop is the outcome of all traceroute echo requests sent with the same TTL.
+type Hop struct {
+	TTL     int
+	Probes  []HopProbe
+	Reached bool // whether the destination itself answered
+}
+
+// HopStatistics is the summary of the probes sent with the same TTL.
+type HopStatistics struct {
+	TTL        int
+	Responders []net.IP // distinct responders in the order of their answers
+	Sent       int      // number of probes sent
+	Received   int      // number of probes answered
+	Loss       float64  // percentage of probes without an answer
+	MinRTT     time.Duration
+	AvgRTT     time.Duration
+	MaxRTT     time.Duration
+}
+
+// Statistics summarizes the probes of the hop.
+func (h Hop) Statistics() HopStatistics {
+	stats := HopStatistics{TTL: h.TTL, Sent: len(h.Probes)}
+	var rtts []time.Duration
+	seen := make(map[string]bool)
+	for _, probe := range h.Probes {
+		if probe.IP == nil {
+			continue
+		}
+		rtts = append(rtts, probe.RTT)
+		if key := probe.IP.String(); !seen[key] {
+			seen[key] = true
+			stats.Responders = append(stats.Responders, probe.IP)
+		}
+	}
+	stats.Received = len(rtts)
+	if stats.Sent > 0 {
+		stats.Loss = float64(stats.Sent-stats.Received) * 100 / float64(stats.Sent)
+	}
+	stats.MinRTT, stats.AvgRTT, stats.MaxRTT, _ = rttSummary(rtts)
+
+	return stats
+}
+
+// Traceroute sends echo requests with TTL growing from 1 until the
+// destination replies, the maximum number of hops is reached or `ctx` is
+// done. It returns the hops traced so far.
+func (p *Pinger) Traceroute(ctx context.Context) ([]Hop, error) {
+	cn, err := p.getConnection(p.network(), p.bindAddr)
+	if err != nil {
+		return nil, err
+	}
+	defer cn.Close()
+
+	ping, stop := p.startReceiving(ctx, cn)
+	defer stop()
+
+	var hops []Hop
+	for ttl := 1; ttl <= p.maxHops; ttl++ {
+		if err := p.setTTL(cn, ttl); err != nil {
+			return hops, err
+		}
+
+		hop := Hop{TTL: ttl}
+		for i := 0; i < probesPerHop; i++ {
+			if err := p.sendEcho(ctx, cn); err != nil {
+				if ctx.Err() != nil {
+					return hops, nil
+				}
+				return hops, err
+			}
+
+			pkt, answered, ok := p.awaitAnswer(ctx, ping)
+			if !ok {
+				return hops, nil
+			}
+			var probe HopProbe
+			if answered {
+				probe = HopProbe{IP: pkt.IP, RTT: pkt.RTT}
+				hop.Reached = hop.Reached || isEchoReply(pkt.Type)
+			}
+			hop.Probes = append(hop.Probes, probe)
+		}
+
+		hops = append(hops, hop)
+		if p.onHop != nil {
+			p.onHop(hop)
+		}
+		if hop.Reached {
+			break
+		}
+	}
+
+	return hops, nil
+}
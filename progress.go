@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressRedraw is the least time between two drawings of the progress bar
+// for finished probes, so thousands of destinations don't flood the terminal.
+const progressRedraw = 100 * time.Millisecond
+
+// maxProgressCells bounds the width of the bar itself, without the counts.
+const maxProgressCells = 40
+
+// progressBar shows the share of finished probes of all destinations on the
+// last line of a terminal, see --progress. Writes to `stdout` and `stderr` go
+// through it, so the bar is erased before each line and drawn again below it.
+type progressBar struct {
+	mu      sync.Mutex
+	w       io.Writer // where `stdout` wrote before
+	term    *os.File  // terminal the bar is drawn on
+	total   int       // probes of all destinations
+	done    int       // probes finished so far
+	drawn   time.Time // when the bar was last drawn
+	shown   bool      // whether the bar is on screen
+	midLine bool      // whether the last write didn't end its line
+}
+
+// newProgressBar returns a bar counting `total` probes on `term`, which
+// takes over `w`.
+func newProgressBar(w io.Writer, term *os.File, total int) *progressBar {
+	return &progressBar{w: w, term: term, total: total}
+}
+
+// Write passes `p` on, keeping the bar below the output. Lines are written
+// at once, so the bar only returns once a line is complete.
+func (b *progressBar) Write(p []byte) (int, error) {
+	return b.writeTo(b.w, p)
+}
+
+// writeTo writes `p` to `w` like Write, for other streams on the terminal.
+func (b *progressBar) writeTo(w io.Writer, p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.erase()
+	n, err := w.Write(p)
+	if len(p) > 0 {
+		b.midLine = p[len(p)-1] != '\n'
+	}
+	b.draw()
+	return n, err
+}
+
+// barWriter passes writes to `w` through the bar, see progressBar.writeTo.
+type barWriter struct {
+	bar *progressBar
+	w   io.Writer
+}
+
+func (bw barWriter) Write(p []byte) (int, error) {
+	return bw.bar.writeTo(bw.w, p)
+}
+
+// add counts `n` more finished probes. Once all are, the bar disappears.
+func (b *progressBar) add(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.done = min(b.done+n, b.total)
+	if b.done == b.total {
+		b.erase()
+		return
+	}
+	if time.Since(b.drawn) >= progressRedraw {
+		b.draw()
+	}
+}
+
+// erase clears the terminal line of the bar if it is shown.
+func (b *progressBar) erase() {
+	if b.shown {
+		fmt.Fprint(b.term, "\r\033[K")
+		b.shown = false
+	}
+}
+
+// draw renders the bar like `[=========>          ] 45/100 probes (45%)`,
+// fitting it into the terminal width if known.
+func (b *progressBar) draw() {
+	if b.midLine || b.done == b.total {
+		return
+	}
+	counts := fmt.Sprintf(" %d/%d probes (%d%%)", b.done, b.total, b.done*100/b.total)
+	cells := maxProgressCells
+	if width := terminalWidth(b.term); width > 0 {
+		cells = min(cells, width-len(counts)-3)
+	}
+
+	bar := ""
+	if cells > 0 {
+		filled := b.done * cells / b.total
+		bar = "[" + strings.Repeat("=", filled) + ">" + strings.Repeat(" ", cells-filled) + "]"
+	}
+	fmt.Fprint(b.term, "\r\033[K"+bar+counts)
+	b.shown = true
+	b.drawn = time.Now()
+}
+
+// progressTask is the share of a single destination in the bar, `count`
+// probes at most.
+type progressTask struct {
+	bar  *progressBar
+	left int // probes not finished yet
+}
+
+// step counts a finished probe, i.e. a reply, an ICMP error or a timeout.
+// Messages beyond the count, e.g. answers caught while draining or
+// retried requests, are ignored.
+func (t *progressTask) step() {
+	if t.left > 0 {
+		t.left--
+		t.bar.add(1)
+	}
+}
+
+// finish counts the probes the run ended without, e.g. at the deadline.
+func (t *progressTask) finish() {
+	t.bar.add(t.left)
+	t.left = 0
+}
+
+// enableProgress sets up the bar for the runs of `targets`, unless stderr
+// isn't a terminal, e.g. piped, or a destination has no count to finish. It
+// replaces `stdout` and `stderr`, so formatters are set up after it.
+func enableProgress(targets []*target) {
+	if !isTerminal(os.Stderr) {
+		return
+	}
+	total := 0
+	for _, t := range targets {
+		if t.cfg.count <= 0 {
+			logger.Warn("Progress bar needs a count (-c), disabling it", "host", t.cfg.host)
+			return
+		}
+		total += t.cfg.count
+	}
+
+	bar := newProgressBar(stdout, os.Stderr, total)
+	stdout = bar
+	// diagnostics share the terminal line of the bar
+	stderr = barWriter{bar: bar, w: stderr}
+	logger = newLogger(stderr)
+	for _, t := range targets {
+		t.out.progress = &progressTask{bar: bar, left: t.cfg.count}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// maxRangeBits bounds the size of address ranges to 2^16 addresses, so huge
+// IPv6 prefixes aren't enumerated.
+const maxRangeBits = 16
+
+// maxDestinations bounds the number of destinations of all arguments and
+// files together, every one takes an ICMP identifier, of which there are
+// 2^16.
+const maxDestinations = 1 << 16
+
+// addrRange is a destination given as a CIDR prefix, e.g. 192.168.1.0/24.
+type addrRange struct {
+	prefix string
+	addrs  []string
+}
+
+// expandRange lists the addresses of the CIDR prefix `s`. The network and
+// broadcast addresses of IPv4 prefixes are left out, unless the prefix has
+// no others (/31 and /32).
+func expandRange(s string) (addrRange, error) {
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return addrRange{}, err
+	}
+	prefix = prefix.Masked()
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	if hostBits > maxRangeBits {
+		return addrRange{}, fmt.Errorf("more than %d addresses", 1<<maxRangeBits)
+	}
+
+	var addrs []string
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		addrs = append(addrs, addr.String())
+	}
+	if prefix.Addr().Is4() && hostBits > 1 {
+		addrs = addrs[1 : len(addrs)-1]
+	}
+
+	return addrRange{prefix: prefix.String(), addrs: addrs}, nil
+}
@@ -0,0 +1,27 @@
+package main
+
+// reachability tracks whether the destination is up. The state flips only
+// after `threshold` consecutive probe results of the other kind, so single
+// losses don't make it flap.
+type reachability struct {
+	threshold int
+	known     bool // whether the state was established yet
+	up        bool
+
+	last   bool // result of the latest probe
+	streak int  // number of consecutive probes with the `last` result
+}
+
+// observe records a probe result and reports whether the state flipped.
+func (r *reachability) observe(up bool) bool {
+	if r.streak == 0 || up != r.last {
+		r.last, r.streak = up, 0
+	}
+	r.streak++
+
+	if r.streak < r.threshold || (r.known && r.up == up) {
+		return false
+	}
+	r.known, r.up = true, up
+	return true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// resolver looks up host names of addresses in the background and caches
+// them, so printing a message never waits for DNS.
+type resolver struct {
+	mu    sync.Mutex
+	names map[string]string // resolved names, "" while pending or failed
+}
+
+func newResolver() *resolver {
+	return &resolver{names: make(map[string]string)}
+}
+
+// format returns `hostname (ip)` if the name of `ip` is already known, and
+// the bare `ip` otherwise. The first call for an address starts its lookup.
+func (r *resolver) format(ip net.IP) string {
+	addr := ip.String()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name, ok := r.names[addr]
+	if !ok {
+		r.names[addr] = ""
+		go r.lookup(addr)
+	}
+	if name == "" {
+		return addr
+	}
+
+	return fmt.Sprintf("%s (%s)", name, addr)
+}
+
+func (r *resolver) lookup(addr string) {
+	names, err := net.LookupAddr(addr)
+	if err != nil || len(names) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	r.names[addr] = strings.TrimSuffix(names[0], ".")
+	r.mu.Unlock()
+}
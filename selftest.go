@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/temirrr/Pinger/pinger"
+)
+
+// selfTestCount is the number of echo requests per IP version sent by
+// --loopback.
+const selfTestCount = 3
+
+// maxSelfTestRTT is the largest round trip time over loopback still counted
+// as sane, generous for loaded CI machines.
+const maxSelfTestRTT = time.Second
+
+// selfTest pings the loopback address of each IP version, exercising the
+// whole send, receive and parse path without a network, and returns the exit
+// code: 0 if every IP version with a loopback address got a sane reply.
+// Versions without one, e.g. IPv6 disabled in a container, are skipped.
+func selfTest(cfg *config) int {
+	code := 0
+	for _, addr := range []string{"127.0.0.1", "::1"} {
+		ip := net.ParseIP(addr)
+		if !hasLocalAddr(ip) {
+			fmt.Fprintf(stdout, "self-test %s: skipped, no such address\n", addr)
+			continue
+		}
+
+		stats, err := selfTestAddr(cfg, addr)
+		if err != nil {
+			fmt.Fprintf(stdout, "self-test %s: FAIL, %s\n", addr, err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(stdout,
+			"self-test %s: ok, %d/%d replies, avg %.3f ms\n",
+			addr,
+			stats.Received,
+			stats.Transmitted,
+			durationToMs(stats.AvgRTT),
+		)
+	}
+
+	return code
+}
+
+// selfTestAddr pings the loopback address `addr` of the self-test and
+// returns the statistics, or an error if it got no sane reply.
+func selfTestAddr(cfg *config, addr string) (pinger.Statistics, error) {
+	var recvErr error
+	p, err := pinger.New(
+		addr,
+		pinger.WithIPv6(net.ParseIP(addr).To4() == nil),
+		pinger.WithUnprivileged(cfg.udp),
+		pinger.WithCount(selfTestCount),
+		pinger.WithInterval(200*time.Millisecond),
+		pinger.WithTimeout(cfg.timeout),
+		pinger.OnError(func(err error) {
+			recvErr = err
+		}),
+	)
+	if err != nil {
+		return pinger.Statistics{}, err
+	}
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestCount*cfg.timeout)
+	defer cancel()
+	stats, err := p.Run(ctx)
+	if err == nil && stats.Received == 0 {
+		err = recvErr
+		if err == nil {
+			err = fmt.Errorf("no reply to %d echo requests", stats.Transmitted)
+		}
+	}
+	if err == nil && (stats.MinRTT <= 0 || stats.MaxRTT > maxSelfTestRTT) {
+		err = fmt.Errorf("implausible round trip times %s-%s", stats.MinRTT, stats.MaxRTT)
+	}
+	return stats, err
+}
+
+// hasLocalAddr reports whether `ip` is assigned to an interface of this host.
+func hasLocalAddr(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSelfTestLoopback(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "::1"} {
+		t.Run(addr, func(t *testing.T) {
+			if !hasLocalAddr(net.ParseIP(addr)) {
+				t.Skipf("%s is not assigned to an interface", addr)
+			}
+			stats, err := selfTestAddr(&config{timeout: time.Second}, addr)
+			// EPERM or EACCES: raw sockets need privileges the test lacks
+			if errors.Is(err, os.ErrPermission) {
+				t.Skipf("no privileges for raw sockets: %v", err)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Received == 0 || stats.Received > stats.Transmitted {
+				t.Errorf("%d/%d replies", stats.Received, stats.Transmitted)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"text/tabwriter"
+)
+
+// printTable prints the final statistics of all destinations as a single
+// column-aligned table, fping style, instead of a summary per destination.
+func printTable(targets []*target) {
+	// numbers align right, host names left by padding them to one width
+	width := len("host")
+	for _, t := range targets {
+		width = max(width, len(t.cfg.host))
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%-*s\taddress\tsent\trecv\tloss\tmin\tavg\tmax\t\n", width, "host")
+	for _, t := range targets {
+		host, ip := fmt.Sprintf("%-*s", width, t.cfg.host), t.p.IPAddr().IP
+		if t.stats == nil {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\t-\t\n", host, ip)
+			continue
+		}
+		s := t.stats
+		rtts := "-\t-\t-"
+		if s.Received > 0 {
+			rtts = fmt.Sprintf(
+				"%.3f\t%.3f\t%.3f",
+				durationToMs(s.MinRTT),
+				durationToMs(s.AvgRTT),
+				durationToMs(s.MaxRTT),
+			)
+		}
+		fmt.Fprintf(w,
+			"%s\t%s\t%d\t%d\t%g%%\t%s\t\n",
+			host,
+			ip,
+			s.Transmitted,
+			s.Received,
+			s.PacketLoss,
+			rtts,
+		)
+	}
+	w.Flush()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/temirrr/Pinger/pinger"
+)
+
+// replyLine holds the fields of an echo reply available to --output-template.
+type replyLine struct {
+	Host  string        // destination as given
+	IP    string        // address of the destination
+	From  string        // address the reply came from
+	Seq   int           // sequence number
+	TTL   int           // incoming TTL, -1 if unknown
+	RTT   time.Duration // round trip time, e.g. 1.234567ms
+	RTTMs float64       // round trip time in milliseconds
+	Bytes int           // number of ICMP bytes
+	Dup   bool          // whether the reply is a duplicate
+}
+
+// parseOutputTemplate compiles the --output-template `s`, terminating lines
+// with a newline unless it ends with one. A trial run against empty fields
+// catches references to unknown fields at startup rather than at the first
+// reply.
+func parseOutputTemplate(s string) (*template.Template, error) {
+	if !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	tmpl, err := template.New("output").Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if err := tmpl.Execute(io.Discard, replyLine{}); err != nil {
+		return nil, err
+	}
+
+	return tmpl, nil
+}
+
+// templateLine renders the reply `pkt` with the --output-template.
+func (f *humanFormatter) templateLine(pkt pinger.Packet) string {
+	var buf bytes.Buffer
+	err := f.template.Execute(&buf, replyLine{
+		Host:  f.host,
+		IP:    f.ip.String(),
+		From:  f.addr(pkt.IP),
+		Seq:   pkt.Seq,
+		TTL:   pkt.TTL,
+		RTT:   pkt.RTT,
+		RTTMs: durationToMs(pkt.RTT),
+		Bytes: pkt.Bytes,
+		Dup:   pkt.Dup,
+	})
+	if err != nil {
+		// the trial run at startup makes this unlikely
+		return err.Error() + "\n"
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,11 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package main
+
+import "os"
+
+// terminalWidth returns 0, the terminal size is unknown on this platform.
+func terminalWidth(f *os.File) int {
+	return 0
+}
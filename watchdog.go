@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// errSilent cancels a run whose destination stopped replying, see
+// --fail-after.
+var errSilent = errors.New("No reply")
+
+// watchdog cancels the run of `ctx` with errSilent once no echo reply has
+// arrived for `limit`, counted from the start until the first one. The
+// returned function stops watching.
+func watchdog(ctx context.Context, lastReply *atomic.Int64, limit time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	lastReply.Store(time.Now().UnixNano())
+
+	// checked often enough to exit at most a tenth of the limit late
+	ticker := time.NewTicker(max(limit/10, time.Millisecond))
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if time.Since(time.Unix(0, lastReply.Load())) >= limit {
+					cancel(fmt.Errorf("%w for %s", errSilent, limit))
+					return
+				}
+			}
+		}
+	}()
+
+	return ctx, func() { cancel(nil) }
+}